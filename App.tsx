@@ -20,7 +20,7 @@ import {
 } from 'lucide-react';
 import { MOCK_PRODUCTS, MOCK_BLOGS, TEST_USERS } from './constants.tsx';
 import { User, UserRole, Product, CartItem, Order, BlogPost } from './types.ts';
-import { apiService } from './services/api.ts';
+import { apiService, Address, ShippingAddress } from './services/api.ts';
 import { 
   LineChart, 
   Line, 
@@ -66,10 +66,11 @@ export default function App() {
       // For now, we'll keep client-side filtering since the backend filtering is more complex
       // In a production app, you'd want to move all filtering to the backend
       const fetchedProducts = await apiService.getProducts();
-      setProducts(fetchedProducts.map(p => ({ 
+      setProducts(fetchedProducts.items.map(p => ({ 
         ...p, 
         id: p.id.toString(),
-        image: p.image_url // Map image_url to image for frontend compatibility
+        image: p.image_url, // Map image_url to image for frontend compatibility
+        category: p.category?.name ?? ''
       })));
     } catch (error) {
       console.error('Failed to fetch products:', error);
@@ -96,7 +97,7 @@ export default function App() {
         fetchCart();
       } catch (error) {
         console.error('Failed to restore session:', error);
-        localStorage.removeItem('token');
+        apiService.logout();
         localStorage.removeItem('user');
       }
     }
@@ -115,7 +116,7 @@ export default function App() {
   const fetchBlogs = async () => {
     try {
       const fetchedBlogs = await apiService.getPublishedBlogs();
-      setBlogs(fetchedBlogs.map(b => ({
+      setBlogs(fetchedBlogs.items.map(b => ({
         id: b.id.toString(),
         title: b.title,
         excerpt: b.excerpt || b.content?.substring(0, 150) + '...',
@@ -147,7 +148,7 @@ export default function App() {
     setView('HOME');
     setCart([]);
     setWishlist([]);
-    localStorage.removeItem('token');
+    apiService.logout();
     localStorage.removeItem('user');
     localStorage.removeItem('wishlist');
   };
@@ -166,7 +167,7 @@ export default function App() {
           name: item.product.name,
           price: item.product.price,
           image: item.product.image_url,
-          category: item.product.category,
+          category: item.product.category?.name ?? '',
           stock: item.product.stock,
         }
       })));
@@ -834,9 +835,49 @@ export default function App() {
 
   // 6.5. Checkout View
   const CheckoutView = () => {
+    const [addresses, setAddresses] = useState<Address[]>([]);
+    // null means the customer is entering a new address
+    const [addressId, setAddressId] = useState<number | null>(null);
+    const [newAddress, setNewAddress] = useState<ShippingAddress>({
+      recipient_name: currentUser?.name || '',
+      phone: '',
+      street: '',
+      ward: '',
+      district: '',
+      province: '',
+    });
+    const [saveAddress, setSaveAddress] = useState(true);
+
+    useEffect(() => {
+      apiService.getAddresses()
+        .then(saved => {
+          setAddresses(saved);
+          const preferred = saved.find(a => a.is_default) || saved[0];
+          if (preferred) setAddressId(preferred.id);
+        })
+        .catch(error => console.error('Failed to fetch addresses:', error));
+    }, []);
+
+    const updateNewAddress = (field: keyof ShippingAddress, value: string) => {
+      setNewAddress(prev => ({ ...prev, [field]: value }));
+    };
+
     const handleCheckout = async () => {
       try {
-        await apiService.createOrder();
+        if (addressId !== null) {
+          await apiService.createOrder({ address_id: addressId });
+        } else {
+          if (Object.values(newAddress).some(value => !value.trim())) {
+            alert('Please fill in the shipping address');
+            return;
+          }
+          if (saveAddress) {
+            const saved = await apiService.createAddress(newAddress);
+            await apiService.createOrder({ address_id: saved.id });
+          } else {
+            await apiService.createOrder({ address: newAddress });
+          }
+        }
         alert('Order placed successfully!');
         setCart([]);
         setView('HOME');
@@ -845,6 +886,15 @@ export default function App() {
       }
     };
 
+    const addressFields: { field: keyof ShippingAddress; placeholder: string; type?: string }[] = [
+      { field: 'recipient_name', placeholder: 'Họ tên người nhận' },
+      { field: 'phone', placeholder: 'Số điện thoại', type: 'tel' },
+      { field: 'street', placeholder: 'Số nhà, tên đường' },
+      { field: 'ward', placeholder: 'Phường/Xã' },
+      { field: 'district', placeholder: 'Quận/Huyện' },
+      { field: 'province', placeholder: 'Tỉnh/Thành phố' },
+    ];
+
     return (
       <div className="py-12 max-w-4xl mx-auto px-4 sm:px-6 lg:px-8">
         <h2 className="text-3xl font-bold mb-8">Thanh toán</h2>
@@ -853,10 +903,58 @@ export default function App() {
             <div className="bg-white p-6 rounded-2xl shadow-sm border border-gray-100">
               <h3 className="text-xl font-bold mb-4">Thông tin giao hàng</h3>
               <div className="space-y-4">
-                <input type="text" placeholder="Họ tên" className="w-full p-3 rounded-xl border border-gray-200 focus:border-emerald-600 focus:outline-none" />
-                <input type="email" placeholder="Email" className="w-full p-3 rounded-xl border border-gray-200 focus:border-emerald-600 focus:outline-none" />
-                <input type="tel" placeholder="Số điện thoại" className="w-full p-3 rounded-xl border border-gray-200 focus:border-emerald-600 focus:outline-none" />
-                <textarea placeholder="Địa chỉ" rows={3} className="w-full p-3 rounded-xl border border-gray-200 focus:border-emerald-600 focus:outline-none"></textarea>
+                {addresses.map(address => (
+                  <label key={address.id} className="flex items-start gap-3 cursor-pointer p-3 rounded-xl border border-gray-200">
+                    <input
+                      type="radio"
+                      name="address"
+                      checked={addressId === address.id}
+                      onChange={() => setAddressId(address.id)}
+                      className="w-4 h-4 mt-1 accent-emerald-600"
+                    />
+                    <span>
+                      <span className="font-medium">{address.recipient_name}</span> · {address.phone}
+                      <span className="block text-sm text-gray-500">
+                        {[address.street, address.ward, address.district, address.province].join(', ')}
+                      </span>
+                    </span>
+                  </label>
+                ))}
+                {addresses.length > 0 && (
+                  <label className="flex items-center gap-3 cursor-pointer">
+                    <input
+                      type="radio"
+                      name="address"
+                      checked={addressId === null}
+                      onChange={() => setAddressId(null)}
+                      className="w-4 h-4 accent-emerald-600"
+                    />
+                    <span>Giao đến địa chỉ khác</span>
+                  </label>
+                )}
+                {addressId === null && (
+                  <>
+                    {addressFields.map(({ field, placeholder, type }) => (
+                      <input
+                        key={field}
+                        type={type || 'text'}
+                        placeholder={placeholder}
+                        value={newAddress[field]}
+                        onChange={(e) => updateNewAddress(field, e.target.value)}
+                        className="w-full p-3 rounded-xl border border-gray-200 focus:border-emerald-600 focus:outline-none"
+                      />
+                    ))}
+                    <label className="flex items-center gap-3 cursor-pointer">
+                      <input
+                        type="checkbox"
+                        checked={saveAddress}
+                        onChange={(e) => setSaveAddress(e.target.checked)}
+                        className="w-4 h-4 accent-emerald-600"
+                      />
+                      <span>Lưu vào sổ địa chỉ</span>
+                    </label>
+                  </>
+                )}
               </div>
             </div>
             <div className="bg-white p-6 rounded-2xl shadow-sm border border-gray-100">
@@ -1063,6 +1161,7 @@ export default function App() {
     const [dashboardData, setDashboardData] = useState<any>({});
     const [users, setUsers] = useState<any[]>([]);
     const [products, setProducts] = useState<any[]>([]);
+    const [categories, setCategories] = useState<{ id: number; name: string }[]>([]);
     const [vouchers, setVouchers] = useState<any[]>([]);
     const [blogs, setBlogs] = useState<any[]>([]);
     const [loading, setLoading] = useState(false);
@@ -1085,7 +1184,7 @@ export default function App() {
       description: '',
       price: '',
       image_url: '',
-      category: '',
+      category_id: '',
       stock: ''
     });
     const [newBlog, setNewBlog] = useState({
@@ -1104,6 +1203,7 @@ export default function App() {
         loadUsers();
       } else if (activeTab === 'products') {
         loadProducts();
+        loadCategories();
       } else if (activeTab === 'vouchers') {
         loadVouchers();
       } else if (activeTab === 'blogs') {
@@ -1123,7 +1223,7 @@ export default function App() {
     const loadUsers = async () => {
       try {
         const data = await apiService.getUsers();
-        setUsers(data);
+        setUsers(data.items);
       } catch (error) {
         console.error('Failed to load users:', error);
       }
@@ -1132,16 +1232,31 @@ export default function App() {
     const loadProducts = async () => {
       try {
         const data = await apiService.getAdminProducts();
-        setProducts(data);
+        setProducts(data.items);
       } catch (error) {
         console.error('Failed to load products:', error);
       }
     };
 
+    const loadCategories = async () => {
+      try {
+        // Flatten the category tree, indenting subcategories under their parent
+        const flatten = (nodes: any[], depth: number): { id: number; name: string }[] =>
+          nodes.flatMap(node => [
+            { id: node.id, name: `${'— '.repeat(depth)}${node.name}` },
+            ...flatten(node.children || [], depth + 1)
+          ]);
+        const data = await apiService.getCategories();
+        setCategories(flatten(data, 0));
+      } catch (error) {
+        console.error('Failed to load categories:', error);
+      }
+    };
+
     const loadVouchers = async () => {
       try {
         const data = await apiService.getVouchers();
-        setVouchers(data);
+        setVouchers(data.items);
       } catch (error) {
         console.error('Failed to load vouchers:', error);
       }
@@ -1150,7 +1265,7 @@ export default function App() {
     const loadBlogs = async () => {
       try {
         const data = await apiService.getBlogs();
-        setBlogs(data);
+        setBlogs(data.items);
       } catch (error) {
         console.error('Failed to load blogs:', error);
       }
@@ -1165,7 +1280,7 @@ export default function App() {
           description: newProduct.description,
           price: parseFloat(newProduct.price),
           image_url: newProduct.image_url,
-          category: newProduct.category,
+          category_id: newProduct.category_id ? parseInt(newProduct.category_id) : null,
           stock: parseInt(newProduct.stock)
         };
         await apiService.createAdminProduct(productData);
@@ -1175,7 +1290,7 @@ export default function App() {
           description: '',
           price: '',
           image_url: '',
-          category: '',
+          category_id: '',
           stock: ''
         });
         // Reload products
@@ -1195,7 +1310,7 @@ export default function App() {
         description: product.description,
         price: product.price.toString(),
         image_url: product.image_url,
-        category: product.category,
+        category_id: product.category_id ? product.category_id.toString() : '',
         stock: product.stock.toString()
       });
       setShowEditProductModal(true);
@@ -1212,7 +1327,7 @@ export default function App() {
           description: newProduct.description,
           price: parseFloat(newProduct.price),
           image_url: newProduct.image_url,
-          category: newProduct.category,
+          category_id: newProduct.category_id ? parseInt(newProduct.category_id) : null,
           stock: parseInt(newProduct.stock)
         };
         await apiService.updateAdminProduct(editingProduct.id, productData);
@@ -1223,7 +1338,7 @@ export default function App() {
           description: '',
           price: '',
           image_url: '',
-          category: '',
+          category_id: '',
           stock: ''
         });
         // Reload products
@@ -1562,7 +1677,7 @@ export default function App() {
                       <tr key={product.id} className="hover:bg-gray-50">
                         <td className="px-6 py-4 text-sm text-gray-900">{product.id}</td>
                         <td className="px-6 py-4 text-sm font-bold text-gray-900">{product.name}</td>
-                        <td className="px-6 py-4 text-sm text-gray-600">{product.category}</td>
+                        <td className="px-6 py-4 text-sm text-gray-600">{product.category?.name}</td>
                         <td className="px-6 py-4 text-sm font-bold text-emerald-600">{formatPrice(product.price)}</td>
                         <td className="px-6 py-4 text-sm text-gray-600">{product.stock}</td>
                         <td className="px-6 py-4 text-sm">
@@ -1784,13 +1899,16 @@ export default function App() {
                 
                 <div>
                   <label className="block text-sm font-medium text-gray-700 mb-1">Danh mục</label>
-                  <input
-                    type="text"
-                    value={newProduct.category}
-                    onChange={(e) => setNewProduct({...newProduct, category: e.target.value})}
+                  <select
+                    value={newProduct.category_id}
+                    onChange={(e) => setNewProduct({...newProduct, category_id: e.target.value})}
                     className="w-full px-3 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-emerald-500 focus:border-emerald-500"
-                    required
-                  />
+                  >
+                    <option value="">Chưa phân loại</option>
+                    {categories.map(category => (
+                      <option key={category.id} value={category.id}>{category.name}</option>
+                    ))}
+                  </select>
                 </div>
                 
                 <div>
@@ -1840,7 +1958,7 @@ export default function App() {
                       description: '',
                       price: '',
                       image_url: '',
-                      category: '',
+                      category_id: '',
                       stock: ''
                     });
                   }}
@@ -1897,13 +2015,16 @@ export default function App() {
                 
                 <div>
                   <label className="block text-sm font-medium text-gray-700 mb-1">Danh mục</label>
-                  <input
-                    type="text"
-                    value={newProduct.category}
-                    onChange={(e) => setNewProduct({...newProduct, category: e.target.value})}
+                  <select
+                    value={newProduct.category_id}
+                    onChange={(e) => setNewProduct({...newProduct, category_id: e.target.value})}
                     className="w-full px-3 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-emerald-500 focus:border-emerald-500"
-                    required
-                  />
+                  >
+                    <option value="">Chưa phân loại</option>
+                    {categories.map(category => (
+                      <option key={category.id} value={category.id}>{category.name}</option>
+                    ))}
+                  </select>
                 </div>
                 
                 <div>
@@ -1928,7 +2049,7 @@ export default function App() {
                         description: '',
                         price: '',
                         image_url: '',
-                        category: '',
+                        category_id: '',
                         stock: ''
                       });
                     }}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var errWrongPassword = errors.New("wrong password")
+
+func userProfile(user *models.User) gin.H {
+	return gin.H{
+		"id":                user.ID,
+		"email":             user.Email,
+		"name":              user.Name,
+		"role":              user.Role,
+		"avatar":            user.Avatar,
+		"phone":             user.Phone,
+		"email_verified_at": user.EmailVerifiedAt,
+		"created_at":        user.CreatedAt,
+	}
+}
+
+func GetMe(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, userProfile(&user))
+}
+
+func UpdateMe(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var profileData struct {
+		Name   *string `json:"name"`
+		Avatar *string `json:"avatar"`
+		Phone  *string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&profileData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if profileData.Name != nil {
+		name := strings.TrimSpace(*profileData.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		updates["name"] = name
+	}
+	if profileData.Avatar != nil {
+		updates["avatar"] = strings.TrimSpace(*profileData.Avatar)
+	}
+	if profileData.Phone != nil {
+		updates["phone"] = strings.TrimSpace(*profileData.Phone)
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, userProfile(&user))
+}
+
+func ChangePassword(c *gin.Context) {
+	userID := c.GetUint("userID")
+	sessionID := c.GetUint("sessionID")
+	var passwordData struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(passwordData.NewPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(passwordData.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(passwordData.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Keep the current session but log out every other device
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, sessionID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": "password changed"}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// DeleteMe closes the caller's account. Personal data is scrubbed from the
+// user row, which is then soft deleted so that orders keep pointing at it.
+func DeleteMe(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var deleteData struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&deleteData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(deleteData.Password)); err != nil {
+			return errWrongPassword
+		}
+		return anonymizeUser(tx, &user)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// anonymizedEmailDomain replaces the email domain of closed accounts.
+const anonymizedEmailDomain = "deleted.invalid"
+
+func anonymizeUser(tx *gorm.DB, user *models.User) error {
+	// Replace the password with a random one nobody knows
+	randomPassword, _, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"email":             fmt.Sprintf("deleted-user-%d@%s", user.ID, anonymizedEmailDomain),
+		"name":              "Deleted user",
+		"avatar":            "",
+		"phone":             "",
+		"password":          string(hashedPassword),
+		"email_verified_at": nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("cart_id IN (?)", tx.Model(&models.Cart{}).Select("id").Where("user_id = ?", user.ID)).
+		Delete(&models.CartItem{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.Cart{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Address{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserToken{}).Error; err != nil {
+		return err
+	}
+	if err := revokeUserSessions(tx, user.ID, "account deleted"); err != nil {
+		return err
+	}
+	return tx.Delete(user).Error
+}
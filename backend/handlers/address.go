@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errAddressRequired = errors.New("shipping address is required")
+
+type addressInput struct {
+	models.ShippingAddress
+	IsDefault bool `json:"is_default"`
+}
+
+func GetAddresses(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var addresses []models.Address
+	if err := db.Where("user_id = ?", userID).Order("is_default DESC, created_at DESC").Find(&addresses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+		return
+	}
+	c.JSON(http.StatusOK, addresses)
+}
+
+func CreateAddress(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var input addressInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Normalize()
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	address := models.Address{
+		UserID:          userID,
+		ShippingAddress: input.ShippingAddress,
+		IsDefault:       input.IsDefault,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// The first address a user saves becomes their default
+		var count int64
+		if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			address.IsDefault = true
+		}
+		if address.IsDefault {
+			if err := clearDefaultAddress(tx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&address).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create address"})
+		return
+	}
+	c.JSON(http.StatusCreated, address)
+}
+
+func UpdateAddress(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+	var address models.Address
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&address).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	input := addressInput{ShippingAddress: address.ShippingAddress, IsDefault: address.IsDefault}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Normalize()
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	address.ShippingAddress = input.ShippingAddress
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if input.IsDefault && !address.IsDefault {
+			if err := clearDefaultAddress(tx, userID); err != nil {
+				return err
+			}
+			address.IsDefault = true
+		}
+		return tx.Save(&address).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update address"})
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+func SetDefaultAddress(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+	var address models.Address
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&address).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := clearDefaultAddress(tx, userID); err != nil {
+			return err
+		}
+		return tx.Model(&address).Update("is_default", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set default address"})
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+func DeleteAddress(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+	var address models.Address
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&address).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		// Promote the most recently created remaining address
+		var next models.Address
+		if err := tx.Where("user_id = ?", userID).Order("created_at DESC").First(&next).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete address"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+}
+
+func clearDefaultAddress(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.Address{}).Where("user_id = ? AND is_default = ?", userID, true).Update("is_default", false).Error
+}
+
+// resolveShippingAddress picks the address an order ships to: a saved
+// address by ID, an inline address, or else the user's default address.
+func resolveShippingAddress(userID uint, addressID *uint, inline *models.ShippingAddress) (models.ShippingAddress, error) {
+	if addressID != nil {
+		var address models.Address
+		if err := db.Where("id = ? AND user_id = ?", *addressID, userID).First(&address).Error; err != nil {
+			return models.ShippingAddress{}, errors.New("address not found")
+		}
+		return address.ShippingAddress, nil
+	}
+	if inline != nil {
+		address := *inline
+		address.Normalize()
+		if err := address.Validate(); err != nil {
+			return models.ShippingAddress{}, err
+		}
+		return address, nil
+	}
+
+	var address models.Address
+	if err := db.Where("user_id = ? AND is_default = ?", userID, true).First(&address).Error; err != nil {
+		return models.ShippingAddress{}, errAddressRequired
+	}
+	return address.ShippingAddress, nil
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"ecommerce-backend/models"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // --- Dashboard ---
@@ -16,76 +21,236 @@ func GetDashboardStats(c *gin.Context) {
 	var orderCount int64
 	var productCount int64
 	var totalRevenue float64
+	var totalRefunded float64
 
 	db.Model(&models.User{}).Count(&userCount)
 	db.Model(&models.Order{}).Count(&orderCount)
 	db.Model(&models.Product{}).Count(&productCount)
-	db.Model(&models.Order{}).Select("COALESCE(SUM(total_amount), 0)").Scan(&totalRevenue)
+	// Revenue leaves out cancelled orders and money refunded since
+	db.Model(&models.Order{}).Where("status <> ?", models.OrderStatusCancelled).
+		Select("COALESCE(SUM(total_amount - refunded_amount), 0)").Scan(&totalRevenue)
+	db.Model(&models.Refund{}).Where("status = ?", models.RefundStatusSucceeded).Select("COALESCE(SUM(amount), 0)").Scan(&totalRefunded)
 
 	c.JSON(http.StatusOK, gin.H{
 		"users":    userCount,
 		"orders":   orderCount,
 		"products": productCount,
 		"revenue":  totalRevenue,
+		"refunded": totalRefunded,
 	})
 }
 
 // --- Users (Employees & Clients) ---
 func GetUsers(c *gin.Context) {
-	var users []models.User
 	role := c.Query("role")
-	query := db
+	query := db.Model(&models.User{})
 	if role != "" {
 		query = query.Where("role = ?", role)
 	}
-	if err := query.Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
-		return
+	respondPage(c, query, newestFirst("users", func(u *models.User) (interface{}, uint) { return u.CreatedAt, u.ID }), "users")
+}
+
+// roleChangeError explains why the caller may not move a user from one role
+// to another, or is empty when the change is allowed. Only admins grant or
+// take away the ADMIN role, nobody changes their own role, and others only
+// grant or take away roles whose permissions they hold themselves.
+// permissions maps the caller's role and both roles to their permissions.
+func roleChangeError(c *gin.Context, userID uint, from, to string, permissions map[string][]string) string {
+	if from == to {
+		return ""
+	}
+	caller := c.MustGet("user").(models.User)
+	if userID != 0 && caller.ID == userID {
+		return "You cannot change your own role"
+	}
+	if caller.Role == models.RoleAdmin {
+		return ""
+	}
+	if from == models.RoleAdmin || to == models.RoleAdmin {
+		return "Only admins can grant or remove the ADMIN role"
+	}
+	held := make(map[string]bool, len(permissions[caller.Role]))
+	for _, code := range permissions[caller.Role] {
+		held[code] = true
+	}
+	for _, role := range []string{from, to} {
+		for _, code := range permissions[role] {
+			if !held[code] {
+				return fmt.Sprintf("The %s role has the %s permission, which you don't have", role, code)
+			}
+		}
+	}
+	return ""
+}
+
+// rolePermissions maps each of the named roles to the codes of its
+// permissions.
+func rolePermissions(names ...string) (map[string][]string, error) {
+	var roles []models.Role
+	if err := db.Preload("Permissions").Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	permissions := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		codes := []string{}
+		for _, permission := range role.Permissions {
+			codes = append(codes, permission.Code)
+		}
+		permissions[role.Name] = codes
+	}
+	return permissions, nil
+}
+
+// checkRoleChange answers with 403 and returns false when the caller may not
+// move a user from one role to another.
+func checkRoleChange(c *gin.Context, userID uint, from, to string) bool {
+	caller := c.MustGet("user").(models.User)
+	permissions, err := rolePermissions(caller.Role, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
 	}
-	c.JSON(http.StatusOK, users)
+	if message := roleChangeError(c, userID, from, to, permissions); message != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": message})
+		return false
+	}
+	return true
 }
 
 func CreateUser(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var userData struct {
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+		Name     string `json:"name"`
+		Phone    string `json:"phone"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&userData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// Hash password if provided
-	if user.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-			return
-		}
-		user.Password = string(hashedPassword)
+	if userData.Role == "" {
+		userData.Role = models.RoleUser
 	}
-	if err := db.Create(&user).Error; err != nil {
+	if !roleExists(userData.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if !checkRoleChange(c, 0, models.RoleUser, userData.Role) {
+		return
+	}
+	if len(userData.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userData.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	user := models.User{
+		Email:    strings.TrimSpace(userData.Email),
+		Password: string(hashedPassword),
+		Name:     strings.TrimSpace(userData.Name),
+		Phone:    strings.TrimSpace(userData.Phone),
+		Role:     userData.Role,
+	}
+	if err := db.Create(&user).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
+	recordAudit(c, models.AuditCreate, "user", user.ID, nil, snapshot(user))
 	c.JSON(http.StatusCreated, user)
 }
 
+// UpdateUser changes the given fields of a user. A new password logs the
+// user out everywhere. Only admins may edit admin accounts.
 func UpdateUser(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	var userData struct {
+		Email    *string `json:"email" binding:"omitempty,email"`
+		Password *string `json:"password"`
+		Name     *string `json:"name"`
+		Phone    *string `json:"phone"`
+		Role     *string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var user models.User
 	if err := db.First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if user.Role == models.RoleAdmin && c.MustGet("user").(models.User).Role != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can edit admin accounts"})
 		return
 	}
-	if err := db.Save(&user).Error; err != nil {
+
+	updates := map[string]interface{}{}
+	if userData.Email != nil {
+		updates["email"] = strings.TrimSpace(*userData.Email)
+	}
+	if userData.Name != nil {
+		updates["name"] = strings.TrimSpace(*userData.Name)
+	}
+	if userData.Phone != nil {
+		updates["phone"] = strings.TrimSpace(*userData.Phone)
+	}
+	if userData.Role != nil && *userData.Role != user.Role {
+		if !roleExists(*userData.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+		if !checkRoleChange(c, user.ID, user.Role, *userData.Role) {
+			return
+		}
+		updates["role"] = *userData.Role
+	}
+	if userData.Password != nil {
+		if len(*userData.Password) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+			return
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*userData.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		updates["password"] = string(hashedPassword)
+	}
+
+	before := snapshot(user)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if userData.Password == nil {
+			return nil
+		}
+		return revokeUserSessions(tx, user.ID, "password changed by an admin")
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	recordAudit(c, models.AuditUpdate, "user", user.ID, before, snapshot(user))
 	c.JSON(http.StatusOK, user)
 }
 
@@ -95,19 +260,34 @@ func DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
-	if err := db.Unscoped().Delete(&models.User{}, id).Error; err != nil {
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	caller := c.MustGet("user").(models.User)
+	if caller.ID == user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot delete your own account here"})
+		return
+	}
+	if user.Role == models.RoleAdmin && caller.Role != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can delete admin accounts"})
+		return
+	}
+	before := snapshot(user)
+	if err := db.Delete(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+	recordAudit(c, models.AuditDelete, "user", user.ID, before, nil)
+	if err := revokeUserSessions(db, uint(id), "user deleted"); err != nil {
+		log.Println("Failed to revoke sessions of deleted user:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User moved to trash"})
 }
 
 // --- Vouchers ---
 func GetVouchers(c *gin.Context) {
-	var vouchers []models.Voucher
-	if err := db.Find(&vouchers).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vouchers"})
-		return
-	}
-	c.JSON(http.StatusOK, vouchers)
+	query := db.Model(&models.Voucher{})
+	respondPage(c, query, newestFirst("vouchers", func(v *models.Voucher) (interface{}, uint) { return v.CreatedAt, v.ID }), "vouchers")
 }
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoleChangeError(t *testing.T) {
+	staff := models.User{ID: 2, Role: models.RoleStaff}
+	admin := models.User{ID: 1, Role: models.RoleAdmin}
+	permissions := map[string][]string{
+		models.RoleUser:  {},
+		models.RoleStaff: {models.PermUsersManage, models.PermOrdersFulfil},
+		"SUPPORT":        {models.PermOrdersFulfil},
+		"ROLE_ADMIN":     {models.PermOrdersFulfil, models.PermRolesManage},
+	}
+	tests := []struct {
+		name    string
+		caller  models.User
+		userID  uint
+		from    string
+		to      string
+		allowed bool
+	}{
+		{"staff creates a customer", staff, 0, models.RoleUser, models.RoleUser, true},
+		{"staff creates an admin", staff, 0, models.RoleUser, models.RoleAdmin, false},
+		{"staff promotes a customer to staff", staff, 5, models.RoleUser, models.RoleStaff, true},
+		{"staff promotes a customer to admin", staff, 5, models.RoleUser, models.RoleAdmin, false},
+		{"staff demotes an admin", staff, 1, models.RoleAdmin, models.RoleUser, false},
+		{"staff promotes itself", staff, 2, models.RoleStaff, models.RoleAdmin, false},
+		{"admin promotes a customer to admin", admin, 5, models.RoleUser, models.RoleAdmin, true},
+		{"admin demotes itself", admin, 1, models.RoleAdmin, models.RoleUser, false},
+		{"unchanged own role", staff, 2, models.RoleStaff, models.RoleStaff, true},
+		{"staff grants a role with fewer permissions", staff, 5, models.RoleUser, "SUPPORT", true},
+		{"staff grants a role with roles:manage", staff, 5, models.RoleUser, "ROLE_ADMIN", false},
+		{"staff creates a user with roles:manage", staff, 0, models.RoleUser, "ROLE_ADMIN", false},
+		{"staff demotes a user with roles:manage", staff, 5, "ROLE_ADMIN", models.RoleUser, false},
+		{"admin grants a role with roles:manage", admin, 5, models.RoleUser, "ROLE_ADMIN", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("user", tt.caller)
+			message := roleChangeError(c, tt.userID, tt.from, tt.to, permissions)
+			if (message == "") != tt.allowed {
+				t.Fatalf("allowed = %v, want %v (%q)", message == "", tt.allowed, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditSnapshot is the JSON form of an entity, field by field.
+type auditSnapshot map[string]json.RawMessage
+
+// Fields left out of audit entries: bookkeeping, derived data and nested
+// relations, whose changes are audited on their own.
+var auditIgnoredFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"category":   true,
+	"children":   true,
+	"image":      true,
+	"images":     true,
+	"media":      true,
+	"options":    true,
+	"variants":   true,
+	"reviews":    true,
+	"renditions": true,
+	"srcset":     true,
+	"user":       true,
+	"items":      true,
+	"history":    true,
+	"payments":   true,
+	"refunds":    true,
+	"refund":     true,
+	"photos":     true,
+	"events":     true,
+	"highlight":  true,
+}
+
+// Fields whose values are never written to the audit log; only the fact that
+// they changed is.
+var auditRedactedFields = map[string]bool{
+	"password": true,
+}
+
+var redactedValue = json.RawMessage(`"[redacted]"`)
+
+func auditKey(item *models.AuditLog) (interface{}, uint) {
+	return item.CreatedAt, item.ID
+}
+
+// snapshot captures v for the audit log. It has to be taken before v is
+// modified, e.g. by binding a request body onto it.
+func snapshot(v interface{}) auditSnapshot {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var snap auditSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil
+	}
+	for field := range snap {
+		if auditIgnoredFields[field] {
+			delete(snap, field)
+		}
+	}
+	return snap
+}
+
+// diffSnapshots returns the fields that differ between before and after.
+// Either may be nil, for creations and deletions.
+func diffSnapshots(before, after auditSnapshot) map[string]models.AuditChange {
+	changes := map[string]models.AuditChange{}
+	for field, value := range before {
+		if other, ok := after[field]; !ok || !bytes.Equal(value, other) {
+			changes[field] = models.AuditChange{Before: value, After: other}
+		}
+	}
+	for field, value := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = models.AuditChange{After: value}
+		}
+	}
+	for field, change := range changes {
+		if auditRedactedFields[field] {
+			if change.Before != nil {
+				change.Before = redactedValue
+			}
+			if change.After != nil {
+				change.After = redactedValue
+			}
+			changes[field] = change
+		}
+	}
+	return changes
+}
+
+// recordAudit writes an audit entry for a change made by the admin in c.
+// Updates that did not change anything are not recorded. Failures are logged
+// rather than failing a request whose change has already been made.
+func recordAudit(c *gin.Context, action, entityType string, entityID uint, before, after auditSnapshot) {
+	changes := diffSnapshots(before, after)
+	if action == models.AuditUpdate && len(changes) == 0 {
+		return
+	}
+	entry := models.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+		IP:         c.ClientIP(),
+		RequestID:  c.GetString("requestID"),
+	}
+	if v, ok := c.Get("user"); ok {
+		if actor, ok := v.(models.User); ok {
+			entry.ActorID = &actor.ID
+			entry.ActorEmail = actor.Email
+		}
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Println("Failed to write audit log:", err)
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	query := db.Model(&models.AuditLog{})
+	if entityType := c.Query("entity_type"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query = query.Where("entity_id = ?", entityID)
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected RFC3339"})
+			return
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected RFC3339"})
+			return
+		}
+		query = query.Where("created_at <= ?", to)
+	}
+
+	respondPage(c, query, newestFirst("audit_logs", auditKey), "audit logs")
+}
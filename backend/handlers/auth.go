@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"ecommerce-backend/models"
 	"ecommerce-backend/utils"
@@ -10,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var db *gorm.DB
@@ -19,25 +24,46 @@ func SetDB(database *gorm.DB) {
 }
 
 func Register(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var registerData struct {
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+		Name     string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&registerData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(registerData.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(registerData.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
-	user.Password = string(hashedPassword)
+	// Self-registered accounts are always unverified customers
+	user := models.User{
+		Email:    strings.TrimSpace(registerData.Email),
+		Password: string(hashedPassword),
+		Name:     strings.TrimSpace(registerData.Name),
+		Role:     models.RoleUser,
+	}
 
-	if err := db.Create(&user).Error; err != nil {
+	if err := db.Create(&user).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
+	if err := sendVerificationEmail(&user); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
 }
 
@@ -63,19 +89,162 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	token, err := utils.GenerateJWT(user.ID)
+	session := models.Session{
+		UserID:    user.ID,
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+	var tokens gin.H
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = issueTokens(tx, &session)
+		return err
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-			"role":  user.Role,
-		},
+	tokens["user"] = gin.H{
+		"id":    user.ID,
+		"email": user.Email,
+		"name":  user.Name,
+		"role":  user.Role,
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func Refresh(c *gin.Context) {
+	var refreshData struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&refreshData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var session models.Session
+	var tokens gin.H
+	reused := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var refreshToken models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", utils.HashToken(refreshData.RefreshToken)).
+			First(&refreshToken).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, refreshToken.SessionID).Error; err != nil {
+			return err
+		}
+		if session.RevokedAt != nil {
+			return errSessionRevoked
+		}
+
+		// A refresh token that was already rotated is being presented again,
+		// so either the client or an attacker holds a stolen copy. Kill the
+		// whole family; the revocation is applied after this transaction.
+		if refreshToken.UsedAt != nil {
+			reused = true
+			return nil
+		}
+
+		now := time.Now()
+		if now.After(refreshToken.ExpiresAt) {
+			return errSessionRevoked
+		}
+		if err := tx.Model(&refreshToken).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		tokens, err = issueTokens(tx, &session)
+		return err
 	})
+	if err == nil && reused {
+		if err := revokeSession(db, session.ID, "refresh token reuse detected"); err != nil {
+			log.Println("Failed to revoke session after refresh token reuse:", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errSessionRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func Logout(c *gin.Context) {
+	var logoutData struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&logoutData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var refreshToken models.RefreshToken
+	if err := db.Where("token_hash = ?", utils.HashToken(logoutData.RefreshToken)).First(&refreshToken).Error; err == nil {
+		if err := revokeSession(db, refreshToken.SessionID, "logout"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// --- Sessions ---
+
+var errSessionRevoked = errors.New("session revoked")
+
+// issueTokens creates a fresh refresh token in the session and a matching
+// access token, extending the session's lifetime.
+func issueTokens(tx *gorm.DB, session *models.Session) (gin.H, error) {
+	refreshToken, refreshHash, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(utils.RefreshTokenTTL)
+	if err := tx.Create(&models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: refreshHash,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(session).Update("expires_at", expiresAt).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, err := utils.GenerateJWT(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func revokeSession(tx *gorm.DB, sessionID uint, reason string) error {
+	return tx.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// revokeUserSessions logs a user out everywhere.
+func revokeUserSessions(tx *gorm.DB, userID uint, reason string) error {
+	return tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
 }
@@ -7,16 +7,33 @@ import (
 	"ecommerce-backend/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
 )
 
 // --- Blogs ---
-func GetBlogs(c *gin.Context) {
-	var blogs []models.Blog
-	if err := db.Find(&blogs).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blogs"})
-		return
+func blogKey(b *models.Blog) (interface{}, uint) {
+	return b.CreatedAt, b.ID
+}
+
+// resolveBlogImage points blog.ImageURL at the media library image chosen
+// by ImageID, and reports false when there is no such image.
+func resolveBlogImage(blog *models.Blog) bool {
+	blog.Image = nil
+	if blog.ImageID == nil {
+		return true
+	}
+	var media models.Media
+	if err := db.Preload("Renditions").First(&media, *blog.ImageID).Error; err != nil {
+		return false
 	}
-	c.JSON(http.StatusOK, blogs)
+	blog.ImageURL = media.URL
+	blog.Image = &media
+	return true
+}
+
+func GetBlogs(c *gin.Context) {
+	query := db.Model(&models.Blog{})
+	respondPage(c, query, newestFirst("blogs", blogKey), "blogs", preload("Image.Renditions"))
 }
 
 func CreateBlog(c *gin.Context) {
@@ -25,10 +42,15 @@ func CreateBlog(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := db.Create(&blog).Error; err != nil {
+	if !resolveBlogImage(&blog) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown image"})
+		return
+	}
+	if err := db.Omit(clause.Associations).Create(&blog).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create blog"})
 		return
 	}
+	recordAudit(c, models.AuditCreate, "blog", blog.ID, nil, snapshot(blog))
 	c.JSON(http.StatusCreated, blog)
 }
 
@@ -43,14 +65,20 @@ func UpdateBlog(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
 		return
 	}
+	before := snapshot(blog)
 	if err := c.ShouldBindJSON(&blog); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := db.Save(&blog).Error; err != nil {
+	if !resolveBlogImage(&blog) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown image"})
+		return
+	}
+	if err := db.Omit(clause.Associations).Save(&blog).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update blog"})
 		return
 	}
+	recordAudit(c, models.AuditUpdate, "blog", blog.ID, before, snapshot(blog))
 	c.JSON(http.StatusOK, blog)
 }
 
@@ -60,21 +88,24 @@ func DeleteBlog(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blog ID"})
 		return
 	}
-	if err := db.Unscoped().Delete(&models.Blog{}, id).Error; err != nil {
+	var blog models.Blog
+	if err := db.First(&blog, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
+		return
+	}
+	before := snapshot(blog)
+	if err := db.Delete(&blog).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete blog"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Blog deleted"})
+	recordAudit(c, models.AuditDelete, "blog", blog.ID, before, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Blog moved to trash"})
 }
 
 // --- Public Blog Routes ---
 func GetPublishedBlogs(c *gin.Context) {
-	var blogs []models.Blog
-	if err := db.Where("published = ?", true).Find(&blogs).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blogs"})
-		return
-	}
-	c.JSON(http.StatusOK, blogs)
+	query := db.Model(&models.Blog{}).Where("published = ?", true)
+	respondPage(c, query, newestFirst("blogs", blogKey), "blogs", preload("Image.Renditions"))
 }
 
 func GetPublishedBlog(c *gin.Context) {
@@ -84,7 +115,7 @@ func GetPublishedBlog(c *gin.Context) {
 		return
 	}
 	var blog models.Blog
-	if err := db.Where("id = ? AND published = ?", id, true).First(&blog).Error; err != nil {
+	if err := db.Preload("Image.Renditions").Where("id = ? AND published = ?", id, true).First(&blog).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
 		return
 	}
@@ -12,7 +12,7 @@ import (
 func GetCart(c *gin.Context) {
 	userID := c.GetUint("userID")
 	var cart models.Cart
-	if err := db.Preload("Items.Product").Where("user_id = ?", userID).FirstOrCreate(&cart, models.Cart{UserID: userID}).Error; err != nil {
+	if err := db.Preload("Items.Product").Preload("Items.Variant.OptionValues").Where("user_id = ?", userID).FirstOrCreate(&cart, models.Cart{UserID: userID}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
 		return
 	}
@@ -22,13 +22,42 @@ func GetCart(c *gin.Context) {
 func AddToCart(c *gin.Context) {
 	userID := c.GetUint("userID")
 	var itemData struct {
-		ProductID uint `json:"product_id"`
-		Quantity  int  `json:"quantity"`
+		ProductID uint  `json:"product_id"`
+		VariantID *uint `json:"variant_id"`
+		Quantity  int   `json:"quantity"`
 	}
 	if err := c.ShouldBindJSON(&itemData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if itemData.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be positive"})
+		return
+	}
+
+	// Products sold in variants must be added as a specific variant
+	var product models.Product
+	if err := db.Preload("Variants").First(&product, itemData.ProductID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if itemData.VariantID == nil && len(product.Variants) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please choose a variant"})
+		return
+	}
+	if itemData.VariantID != nil {
+		found := false
+		for _, variant := range product.Variants {
+			if variant.ID == *itemData.VariantID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variant does not belong to this product"})
+			return
+		}
+	}
 
 	var cart models.Cart
 	if err := db.Where("user_id = ?", userID).FirstOrCreate(&cart, models.Cart{UserID: userID}).Error; err != nil {
@@ -37,11 +66,18 @@ func AddToCart(c *gin.Context) {
 	}
 
 	var cartItem models.CartItem
-	if err := db.Where("cart_id = ? AND product_id = ?", cart.ID, itemData.ProductID).First(&cartItem).Error; err != nil {
+	itemQuery := db.Where("cart_id = ? AND product_id = ?", cart.ID, itemData.ProductID)
+	if itemData.VariantID != nil {
+		itemQuery = itemQuery.Where("variant_id = ?", *itemData.VariantID)
+	} else {
+		itemQuery = itemQuery.Where("variant_id IS NULL")
+	}
+	if err := itemQuery.First(&cartItem).Error; err != nil {
 		// Create new item
 		cartItem = models.CartItem{
 			CartID:    cart.ID,
 			ProductID: itemData.ProductID,
+			VariantID: itemData.VariantID,
 			Quantity:  itemData.Quantity,
 		}
 		if err := db.Create(&cartItem).Error; err != nil {
@@ -105,4 +141,17 @@ func RemoveFromCart(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Item removed from cart"})
-}
\ No newline at end of file
+}
+
+// cartSubtotal prices the user's cart at current product prices.
+func cartSubtotal(userID uint) (float64, error) {
+	var subtotal float64
+	err := db.Model(&models.CartItem{}).
+		Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Joins("JOIN products ON products.id = cart_items.product_id AND products.deleted_at IS NULL").
+		Joins("LEFT JOIN product_variants ON product_variants.id = cart_items.variant_id").
+		Where("carts.user_id = ?", userID).
+		Select("COALESCE(SUM(COALESCE(product_variants.price, products.price) * cart_items.quantity), 0)").
+		Scan(&subtotal).Error
+	return subtotal, err
+}
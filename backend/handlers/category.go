@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errCategoryCycle = errors.New("category cannot be moved below itself")
+
+// categorySubtree returns a subquery selecting the IDs of the category with
+// the given slug or ID and of all its descendants.
+func categorySubtree(slugOrID string) *gorm.DB {
+	return db.Raw(`WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE slug = ? OR CAST(id AS TEXT) = ?
+		UNION ALL
+		SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+	) SELECT id FROM subtree`, slugOrID, slugOrID)
+}
+
+func categoryExists(id uint) bool {
+	var count int64
+	db.Model(&models.Category{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// checkCategoryParent makes sure parentID exists and is not the category
+// itself or one of its descendants.
+func checkCategoryParent(tx *gorm.DB, categoryID uint, parentID *uint) error {
+	for current := parentID; current != nil; {
+		if categoryID != 0 && *current == categoryID {
+			return errCategoryCycle
+		}
+		var parent models.Category
+		if err := tx.Select("id", "parent_id").First(&parent, *current).Error; err != nil {
+			return err
+		}
+		current = parent.ParentID
+	}
+	return nil
+}
+
+func categoryError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, errCategoryCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category cannot be moved below itself"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "Slug is already in use"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " category"})
+	}
+}
+
+// --- Public category routes ---
+
+// GetCategoryTree returns the whole category tree. Each node's product count
+// includes the products of its descendants.
+func GetCategoryTree(c *gin.Context) {
+	var categories []models.Category
+	if err := db.Order("sort_order ASC, name ASC").Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	var counts []struct {
+		CategoryID uint
+		Count      int64
+	}
+	if err := db.Model(&models.Product{}).Select("category_id, COUNT(*) AS count").
+		Where("category_id IS NOT NULL").Group("category_id").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+		return
+	}
+	direct := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		direct[count.CategoryID] = count.Count
+	}
+
+	children := map[uint][]models.Category{}
+	for _, category := range categories {
+		var parentID uint
+		if category.ParentID != nil {
+			parentID = *category.ParentID
+		}
+		children[parentID] = append(children[parentID], category)
+	}
+
+	var build func(parentID uint) []models.Category
+	build = func(parentID uint) []models.Category {
+		nodes := children[parentID]
+		for i := range nodes {
+			nodes[i].Children = build(nodes[i].ID)
+			nodes[i].ProductCount = direct[nodes[i].ID]
+			for _, child := range nodes[i].Children {
+				nodes[i].ProductCount += child.ProductCount
+			}
+		}
+		return nodes
+	}
+
+	tree := build(0)
+	if tree == nil {
+		tree = []models.Category{}
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// --- Category management ---
+func GetCategories(c *gin.Context) {
+	var categories []models.Category
+	if err := db.Order("sort_order ASC, name ASC").Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+	c.JSON(http.StatusOK, categories)
+}
+
+func CreateCategory(c *gin.Context) {
+	var category models.Category
+	if err := c.ShouldBindJSON(&category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	category.ID = 0
+	category.Children = nil
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if category.Slug = utils.Slugify(category.Slug); category.Slug == "" {
+		category.Slug = utils.Slugify(category.Name)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCategoryParent(tx, 0, category.ParentID); err != nil {
+			return err
+		}
+		return tx.Create(&category).Error
+	})
+	if err != nil {
+		categoryError(c, err, "create")
+		return
+	}
+	recordAudit(c, models.AuditCreate, "category", category.ID, nil, snapshot(category))
+	c.JSON(http.StatusCreated, category)
+}
+
+func UpdateCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+	var category models.Category
+	if err := db.First(&category, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	before := snapshot(category)
+	if err := c.ShouldBindJSON(&category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	category.ID = uint(id)
+	category.Children = nil
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if category.Slug = utils.Slugify(category.Slug); category.Slug == "" {
+		category.Slug = utils.Slugify(category.Name)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCategoryParent(tx, category.ID, category.ParentID); err != nil {
+			return err
+		}
+		return tx.Omit("Children").Save(&category).Error
+	})
+	if err != nil {
+		categoryError(c, err, "update")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, "category", category.ID, before, snapshot(category))
+	c.JSON(http.StatusOK, category)
+}
+
+func DeleteCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	var category models.Category
+	if err := db.First(&category, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	var childCount, productCount int64
+	db.Model(&models.Category{}).Where("parent_id = ?", id).Count(&childCount)
+	db.Unscoped().Model(&models.Product{}).Where("category_id = ?", id).Count(&productCount)
+	if childCount > 0 || productCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has subcategories or products"})
+		return
+	}
+
+	if err := db.Delete(&category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "category", category.ID, snapshot(category), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted"})
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ecommerce-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// priceBucketBounds are the upper bounds, in VND, of the price facet buckets.
+// The last bucket has no upper bound.
+var priceBucketBounds = []float64{1000000, 3000000, 5000000, 10000000}
+
+var ratingThresholds = []int{4, 3, 2, 1}
+
+type productPage struct {
+	*Page[models.Product]
+	Facets *ProductFacets `json:"facets,omitempty"`
+}
+
+// ProductFacets counts the products each filter value would yield. Every
+// facet is computed against all active filters except its own, so that
+// picking one value doesn't hide the alternatives.
+type ProductFacets struct {
+	Categories   []CategoryFacet   `json:"categories"`
+	Prices       []PriceFacet      `json:"prices"`
+	Availability AvailabilityFacet `json:"availability"`
+	Ratings      []RatingFacet     `json:"ratings"`
+	Attributes   []AttributeFacet  `json:"attributes"`
+}
+
+// CategoryFacet counts include products in descendant categories.
+type CategoryFacet struct {
+	ID       uint   `json:"id"`
+	ParentID *uint  `json:"parent_id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Count    int64  `json:"count"`
+}
+
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
+
+type AvailabilityFacet struct {
+	InStock    int64 `json:"in_stock"`
+	OutOfStock int64 `json:"out_of_stock"`
+}
+
+type RatingFacet struct {
+	MinRating int   `json:"min_rating"`
+	Count     int64 `json:"count"`
+}
+
+type AttributeFacet struct {
+	Name   string                `json:"name"`
+	Values []AttributeValueFacet `json:"values"`
+}
+
+type AttributeValueFacet struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+func productFacets(filters []productFilter) (*ProductFacets, error) {
+	facets := &ProductFacets{}
+	var err error
+	if facets.Categories, err = categoryFacets(filters); err != nil {
+		return nil, err
+	}
+	if facets.Prices, err = priceFacets(filters); err != nil {
+		return nil, err
+	}
+	if err = filteredProducts(filters, "availability").
+		Select("COUNT(*) FILTER (WHERE products.stock > 0) AS in_stock, COUNT(*) FILTER (WHERE products.stock <= 0) AS out_of_stock").
+		Scan(&facets.Availability).Error; err != nil {
+		return nil, err
+	}
+	if facets.Ratings, err = ratingFacets(filters); err != nil {
+		return nil, err
+	}
+	if facets.Attributes, err = attributeFacets(filters); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+func categoryFacets(filters []productFilter) ([]CategoryFacet, error) {
+	var counts []struct {
+		CategoryID uint
+		Count      int64
+	}
+	if err := filteredProducts(filters, "category").
+		Select("products.category_id, COUNT(*) AS count").
+		Where("products.category_id IS NOT NULL").
+		Group("products.category_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []models.Category
+	if err := db.Order("sort_order ASC, name ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[uint]*uint, len(categories))
+	for _, category := range categories {
+		parents[category.ID] = category.ParentID
+	}
+
+	// Roll every count up to all ancestors of its category
+	totals := map[uint]int64{}
+	for _, count := range counts {
+		for id := &count.CategoryID; id != nil; id = parents[*id] {
+			totals[*id] += count.Count
+		}
+	}
+
+	facets := []CategoryFacet{}
+	for _, category := range categories {
+		if total := totals[category.ID]; total > 0 {
+			facets = append(facets, CategoryFacet{
+				ID:       category.ID,
+				ParentID: category.ParentID,
+				Name:     category.Name,
+				Slug:     category.Slug,
+				Count:    total,
+			})
+		}
+	}
+	return facets, nil
+}
+
+func priceFacets(filters []productFilter) ([]PriceFacet, error) {
+	var cases strings.Builder
+	cases.WriteString("CASE")
+	for i, bound := range priceBucketBounds {
+		fmt.Fprintf(&cases, " WHEN products.price < %f THEN %d", bound, i)
+	}
+	fmt.Fprintf(&cases, " ELSE %d END", len(priceBucketBounds))
+
+	var rows []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := filteredProducts(filters, "price").
+		Select(cases.String() + " AS bucket, COUNT(*) AS count").
+		Group("bucket").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Bucket] = row.Count
+	}
+
+	facets := make([]PriceFacet, 0, len(priceBucketBounds)+1)
+	min := 0.0
+	for i := 0; i <= len(priceBucketBounds); i++ {
+		facet := PriceFacet{Min: min, Count: counts[i]}
+		if i < len(priceBucketBounds) {
+			max := priceBucketBounds[i]
+			facet.Max = &max
+			min = max
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+func ratingFacets(filters []productFilter) ([]RatingFacet, error) {
+	selects := make([]string, len(ratingThresholds))
+	for i, threshold := range ratingThresholds {
+		selects[i] = fmt.Sprintf("COUNT(*) FILTER (WHERE products.rating >= %d) AS r%d", threshold, threshold)
+	}
+	row := map[string]interface{}{}
+	if err := filteredProducts(filters, "rating").Select(strings.Join(selects, ", ")).Take(&row).Error; err != nil {
+		return nil, err
+	}
+
+	facets := make([]RatingFacet, len(ratingThresholds))
+	for i, threshold := range ratingThresholds {
+		count, _ := row[fmt.Sprintf("r%d", threshold)].(int64)
+		facets[i] = RatingFacet{MinRating: threshold, Count: count}
+	}
+	return facets, nil
+}
+
+type attributeCount struct {
+	Name  string
+	Value string
+	Count int64
+}
+
+// attributeFacets counts products per product option value. Options with an
+// active filter are counted without that filter.
+func attributeFacets(filters []productFilter) ([]AttributeFacet, error) {
+	counts, err := countAttributes(filteredProducts(filters, ""), "")
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := map[string]bool{}
+	for _, filter := range filters {
+		if name := strings.TrimPrefix(filter.facet, "attr:"); name != filter.facet {
+			filtered[name] = true
+		}
+	}
+	byName := map[string][]AttributeValueFacet{}
+	for _, count := range counts {
+		if !filtered[count.Name] {
+			byName[count.Name] = append(byName[count.Name], AttributeValueFacet{Value: count.Value, Count: count.Count})
+		}
+	}
+	for name := range filtered {
+		counts, err := countAttributes(filteredProducts(filters, "attr:"+name), name)
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range counts {
+			byName[name] = append(byName[name], AttributeValueFacet{Value: count.Value, Count: count.Count})
+		}
+	}
+
+	facets := []AttributeFacet{}
+	for name, values := range byName {
+		facets = append(facets, AttributeFacet{Name: name, Values: values})
+	}
+	sort.Slice(facets, func(i, j int) bool { return facets[i].Name < facets[j].Name })
+	return facets, nil
+}
+
+func countAttributes(query *gorm.DB, onlyName string) ([]attributeCount, error) {
+	query = query.
+		Joins("JOIN product_options ON product_options.product_id = products.id").
+		Joins("JOIN product_option_values ON product_option_values.option_id = product_options.id").
+		Select("product_options.name AS name, product_option_values.value AS value, COUNT(DISTINCT products.id) AS count").
+		Group("product_options.name, product_option_values.value").
+		Order("product_options.name, product_option_values.value")
+	if onlyName != "" {
+		query = query.Where("product_options.name = ?", onlyName)
+	}
+	var counts []attributeCount
+	err := query.Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotentRequests(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "correct horse")
+	product := createTestProduct(t, 100000, 10)
+	t.Cleanup(func() {
+		db.Where("user_id = ?", user.ID).Delete(&models.IdempotencyKey{})
+		db.Where("cart_id IN (?)", db.Model(&models.Cart{}).Select("id").Where("user_id = ?", user.ID)).Delete(&models.CartItem{})
+		db.Where("user_id = ?", user.ID).Delete(&models.Cart{})
+	})
+
+	r := gin.New()
+	r.POST("/cart/add", asUser(user), middleware.Idempotent(), AddToCart)
+	addToCart := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/cart/add", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	cartQuantity := func() int {
+		var quantity int
+		db.Model(&models.CartItem{}).Joins("JOIN carts ON carts.id = cart_items.cart_id").
+			Where("carts.user_id = ?", user.ID).Select("COALESCE(SUM(cart_items.quantity), 0)").Scan(&quantity)
+		return quantity
+	}
+
+	key := fmt.Sprintf("add-%d", time.Now().UnixNano())
+	first := addToCart(key, fmt.Sprintf(`{"product_id": %d, "quantity": 1}`, product.ID))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: %d %s", first.Code, first.Body)
+	}
+	if first.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Fatal("first request was marked as replayed")
+	}
+
+	// A retry is answered from the stored response, even with its JSON
+	// spelled differently
+	retry := addToCart(key, fmt.Sprintf(`{"quantity":1,"product_id":%d}`, product.ID))
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry got %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+		t.Fatal("retry was not marked as replayed")
+	}
+	if quantity := cartQuantity(); quantity != 1 {
+		t.Fatalf("cart has %d units after a retry, want 1", quantity)
+	}
+
+	// The key can't be reused for a different request
+	other := addToCart(key, fmt.Sprintf(`{"product_id": %d, "quantity": 2}`, product.ID))
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("different request with the same key: %d %s, want 422", other.Code, other.Body)
+	}
+	if quantity := cartQuantity(); quantity != 1 {
+		t.Fatalf("cart has %d units after a rejected request, want 1", quantity)
+	}
+
+	// A new key is a new request
+	if w := addToCart(key+"-other", fmt.Sprintf(`{"product_id": %d, "quantity": 2}`, product.ID)); w.Code != http.StatusOK {
+		t.Fatalf("request with a new key: %d %s", w.Code, w.Body)
+	}
+	if quantity := cartQuantity(); quantity != 3 {
+		t.Fatalf("cart has %d units, want 3", quantity)
+	}
+}
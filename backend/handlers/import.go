@@ -0,0 +1,571 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxImportSize   = 20 << 20
+	importBatchSize = 100
+	// Files with up to this many rows are imported before responding; larger
+	// ones run in the background and are polled through their job.
+	importSyncRows  = 200
+	exportBatchSize = 500
+)
+
+// importColumns are the spreadsheet columns, in export order. Only sku is
+// required in an import, plus name and price for new products. Columns left
+// out keep their current values, as do blank name, price and stock cells;
+// other blank cells clear the field.
+var importColumns = []string{"sku", "name", "description", "category", "price", "stock", "image_url"}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// errDryRun rolls back the transaction of a dry-run import batch.
+var errDryRun = errors.New("dry run")
+
+type importRow struct {
+	line   int
+	values map[string]string
+}
+
+func spreadsheetFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".xlsx":
+		return "xlsx"
+	}
+	return ""
+}
+
+// readSpreadsheet returns the cells of a CSV file or of the first sheet of
+// an XLSX workbook.
+func readSpreadsheet(format string, data []byte) ([][]string, error) {
+	if format == "xlsx" {
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New("file is not a valid XLSX workbook")
+		}
+		defer f.Close()
+		return f.GetRows(f.GetSheetName(0), excelize.Options{RawCellValue: true})
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("file is not valid CSV: %w", err)
+	}
+	return records, nil
+}
+
+// parseImportRows keys every row by the header. Unknown columns are rejected
+// so that a misspelt header does not silently skip data.
+func parseImportRows(records [][]string) ([]importRow, error) {
+	if len(records) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	known := make(map[string]bool, len(importColumns))
+	for _, column := range importColumns {
+		known[column] = true
+	}
+	header := make([]string, len(records[0]))
+	seen := map[string]bool{}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !known[name] {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(importColumns, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+	if !seen["sku"] {
+		return nil, errors.New("the sku column is required")
+	}
+
+	var rows []importRow
+	for i, record := range records[1:] {
+		row := importRow{line: i + 2, values: map[string]string{}}
+		blank := true
+		for j, name := range header {
+			if j < len(record) {
+				row.values[name] = strings.TrimSpace(record[j])
+				blank = blank && row.values[name] == ""
+			} else {
+				row.values[name] = ""
+			}
+		}
+		if !blank {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// importCategoryIndex maps lowercase category slugs and names to IDs. Slugs
+// win when a name equals another category's slug.
+func importCategoryIndex() (map[string]uint, error) {
+	var categories []models.Category
+	if err := db.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	index := make(map[string]uint, 2*len(categories))
+	for _, category := range categories {
+		index[strings.ToLower(category.Name)] = category.ID
+	}
+	for _, category := range categories {
+		index[strings.ToLower(category.Slug)] = category.ID
+	}
+	return index, nil
+}
+
+type importedFields struct {
+	name, description, imageURL string
+	categoryID                  uint
+	price                       float64
+	stock                       int
+}
+
+func importedFieldsOf(p *models.Product) importedFields {
+	fields := importedFields{name: p.Name, description: p.Description, imageURL: p.ImageURL, price: p.Price, stock: p.Stock}
+	if p.CategoryID != nil {
+		fields.categoryID = *p.CategoryID
+	}
+	return fields
+}
+
+// changedColumns lists the product columns that differ between before and
+// after, so that an import does not write back columns it did not change.
+func changedColumns(before, after importedFields) []string {
+	var columns []string
+	if before.name != after.name {
+		columns = append(columns, "name", "search_name")
+	}
+	if before.description != after.description {
+		columns = append(columns, "description", "search_body")
+	}
+	if before.imageURL != after.imageURL {
+		columns = append(columns, "image_url")
+	}
+	if before.categoryID != after.categoryID {
+		columns = append(columns, "category_id")
+	}
+	if before.price != after.price {
+		columns = append(columns, "price")
+	}
+	if before.stock != after.stock {
+		columns = append(columns, "stock")
+	}
+	return columns
+}
+
+// importProductRow creates or updates the product with the row's SKU and
+// returns the action taken, or ImportActionError with the reasons.
+func importProductRow(tx *gorm.DB, row importRow, categories map[string]uint) (string, []string) {
+	sku := row.values["sku"]
+	if sku == "" {
+		return models.ImportActionError, []string{"sku is required"}
+	}
+
+	// Locked so that orders placed meanwhile cannot have their stock
+	// changes overwritten
+	var product models.Product
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Where("sku = ?", sku).First(&product).Error
+	exists := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.ImportActionError, []string{"failed to look up product"}
+	}
+	if exists && product.DeletedAt.Valid {
+		return models.ImportActionError, []string{"sku belongs to a deleted product"}
+	}
+	before := importedFieldsOf(&product)
+
+	var errs []string
+	if name, ok := row.values["name"]; ok && name != "" {
+		product.Name = name
+	} else if !exists {
+		errs = append(errs, "name is required")
+	}
+	if description, ok := row.values["description"]; ok {
+		product.Description = description
+	}
+	if category, ok := row.values["category"]; ok {
+		if category == "" {
+			product.CategoryID = nil
+		} else if id, found := categories[strings.ToLower(category)]; found {
+			product.CategoryID = &id
+		} else {
+			errs = append(errs, fmt.Sprintf("unknown category %q", category))
+		}
+	}
+	if value, ok := row.values["price"]; ok && value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			errs = append(errs, "price must be a non-negative number")
+		} else {
+			product.Price = price
+		}
+	} else if !exists {
+		errs = append(errs, "price is required")
+	}
+	if value, ok := row.values["stock"]; ok && value != "" {
+		stock, err := strconv.Atoi(value)
+		if err != nil || stock < 0 {
+			errs = append(errs, "stock must be a non-negative whole number")
+		} else {
+			if exists && stock != product.Stock {
+				var variantCount int64
+				tx.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variantCount)
+				if variantCount > 0 {
+					errs = append(errs, "stock of a product with variants is the sum of its variants")
+				}
+			}
+			product.Stock = stock
+		}
+	}
+	if imageURL, ok := row.values["image_url"]; ok && imageURL != product.ImageURL {
+		if product.ImageID != nil {
+			errs = append(errs, "image_url of a product with a gallery follows its first image")
+		}
+		product.ImageURL = imageURL
+	}
+	if len(errs) > 0 {
+		return models.ImportActionError, errs
+	}
+
+	if !exists {
+		product.SKU = &sku
+		if err := tx.Omit(clause.Associations).Create(&product).Error; err != nil {
+			return models.ImportActionError, []string{"failed to create product"}
+		}
+		return models.ImportActionCreate, nil
+	}
+	columns := changedColumns(before, importedFieldsOf(&product))
+	if len(columns) == 0 {
+		return models.ImportActionUnchanged, nil
+	}
+	if err := tx.Model(&product).Select(columns).Updates(&product).Error; err != nil {
+		return models.ImportActionError, []string{"failed to update product"}
+	}
+	return models.ImportActionUpdate, nil
+}
+
+// runImport imports rows in batches, one transaction per batch and a
+// savepoint per row so that a bad row does not undo its neighbours. Progress
+// is saved after every batch.
+func runImport(job *models.ImportJob, rows []importRow) {
+	now := time.Now()
+	job.Status = models.ImportStatusRunning
+	job.StartedAt = &now
+	db.Model(job).Select("status", "started_at").Updates(job)
+
+	categories, err := importCategoryIndex()
+	if err != nil {
+		failImport(job, err)
+		return
+	}
+
+	firstSeen := map[string]int{}
+	report := make([]models.ImportRowResult, 0, len(rows))
+	for start := 0; start < len(rows); start += importBatchSize {
+		batch := rows[start:min(start+importBatchSize, len(rows))]
+		var results []models.ImportRowResult
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range batch {
+				result := models.ImportRowResult{Row: row.line, SKU: row.values["sku"]}
+				if line, dup := firstSeen[result.SKU]; dup {
+					result.Action = models.ImportActionError
+					result.Errors = []string{fmt.Sprintf("sku already appears on row %d", line)}
+				} else {
+					if result.SKU != "" {
+						firstSeen[result.SKU] = row.line
+					}
+					if err := tx.SavePoint("import_row").Error; err != nil {
+						return err
+					}
+					result.Action, result.Errors = importProductRow(tx, row, categories)
+					if result.Action == models.ImportActionError {
+						if err := tx.RollbackTo("import_row").Error; err != nil {
+							return err
+						}
+					}
+				}
+				results = append(results, result)
+			}
+			if job.DryRun {
+				return errDryRun
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errDryRun) {
+			failImport(job, err)
+			return
+		}
+
+		for _, result := range results {
+			switch result.Action {
+			case models.ImportActionCreate:
+				job.Created++
+			case models.ImportActionUpdate:
+				job.Updated++
+			case models.ImportActionUnchanged:
+				job.Unchanged++
+			default:
+				job.Failed++
+			}
+		}
+		report = append(report, results...)
+		job.ProcessedRows += len(batch)
+		db.Model(job).Select("processed_rows", "created", "updated", "unchanged", "failed").Updates(job)
+	}
+
+	finished := time.Now()
+	job.Status = models.ImportStatusCompleted
+	job.Report = report
+	job.FinishedAt = &finished
+	if err := db.Save(job).Error; err != nil {
+		log.Println("Failed to save import job", job.ID, "-", err)
+	}
+}
+
+func failImport(job *models.ImportJob, err error) {
+	log.Println("Import job", job.ID, "failed:", err)
+	finished := time.Now()
+	job.Status = models.ImportStatusFailed
+	job.Error = err.Error()
+	job.FinishedAt = &finished
+	db.Model(job).Select("status", "error", "finished_at").Updates(job)
+}
+
+// FailInterruptedImports marks imports that were running when the server
+// stopped as failed. Batches already committed stay imported.
+func FailInterruptedImports() {
+	db.Model(&models.ImportJob{}).
+		Where("status IN ?", []string{models.ImportStatusPending, models.ImportStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":      models.ImportStatusFailed,
+			"error":       "interrupted by a server restart",
+			"finished_at": time.Now(),
+		})
+}
+
+// --- Product import & export ---
+
+// ImportProducts upserts products by SKU from the CSV or XLSX "file" field
+// of a multipart form. With dry_run=true every row is validated and
+// reported but nothing is saved.
+func ImportProducts(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize+1<<20)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", maxImportSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file field is required"})
+		return
+	}
+	defer file.Close()
+
+	format := spreadsheetFormat(header.Filename)
+	if format == "" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only .csv and .xlsx files can be imported"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if len(data) > maxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", maxImportSize>>20)})
+		return
+	}
+	records, err := readSpreadsheet(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := parseImportRows(records)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File has no product rows"})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.DefaultPostForm("dry_run", c.Query("dry_run")))
+	job := models.ImportJob{
+		UserID:    c.GetUint("userID"),
+		Filename:  filepath.Base(header.Filename),
+		Format:    format,
+		DryRun:    dryRun,
+		Status:    models.ImportStatusPending,
+		TotalRows: len(rows),
+	}
+	if err := db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+	// Rows are not audited one by one; the job report lists what each changed
+	if !dryRun {
+		recordAudit(c, models.AuditCreate, "import_job", job.ID, nil, snapshot(job))
+	}
+
+	if len(rows) <= importSyncRows {
+		runImport(&job, rows)
+		c.JSON(http.StatusOK, job)
+		return
+	}
+	background := job
+	go runImport(&background, rows)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func GetImportJobs(c *gin.Context) {
+	query := db.Model(&models.ImportJob{}).Omit("report")
+	respondPage(c, query, newestFirst("import_jobs", func(j *models.ImportJob) (interface{}, uint) { return j.CreatedAt, j.ID }), "import jobs")
+}
+
+// GetImportJob reports the progress of an import and, once it is done, the
+// result of every row; errors_only=true keeps only the failed rows.
+func GetImportJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import job ID"})
+		return
+	}
+	var job models.ImportJob
+	if err := db.First(&job, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+	if errorsOnly, _ := strconv.ParseBool(c.Query("errors_only")); errorsOnly {
+		failed := []models.ImportRowResult{}
+		for _, result := range job.Report {
+			if result.Action == models.ImportActionError {
+				failed = append(failed, result)
+			}
+		}
+		job.Report = failed
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func exportValues(p *models.Product) []interface{} {
+	sku, category := "", ""
+	if p.SKU != nil {
+		sku = *p.SKU
+	}
+	if p.Category != nil {
+		category = p.Category.Slug
+	}
+	return []interface{}{sku, p.Name, p.Description, category, p.Price, p.Stock, p.ImageURL}
+}
+
+// ExportProducts downloads the products matching the listing filters as
+// CSV or XLSX, in the column layout ImportProducts reads back.
+func ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+	filters, _ := productFilters(c)
+	query := filteredProducts(filters, "").Preload("Category")
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("20060102-150405"), format)
+
+	var products []models.Product
+	if format == "xlsx" {
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		sw, err := f.NewStreamWriter(sheet)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export products"})
+			return
+		}
+		header := make([]interface{}, len(importColumns))
+		for i, column := range importColumns {
+			header[i] = column
+		}
+		sw.SetRow("A1", header)
+		line := 1
+		err = query.FindInBatches(&products, exportBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range products {
+				line++
+				cell, _ := excelize.CoordinatesToCellName(1, line)
+				if err := sw.SetRow(cell, exportValues(&products[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+		if err == nil {
+			err = sw.Flush()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export products"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err := f.Write(c.Writer); err != nil {
+			log.Println("Failed to write product export:", err)
+		}
+		return
+	}
+
+	// CSV is streamed; the BOM makes Excel read Vietnamese text as UTF-8
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Writer.Write(utf8BOM)
+	w := csv.NewWriter(c.Writer)
+	w.Write(importColumns)
+	err := query.FindInBatches(&products, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range products {
+			values := exportValues(&products[i])
+			record := make([]string, len(values))
+			for j, value := range values {
+				switch v := value.(type) {
+				case float64:
+					record[j] = strconv.FormatFloat(v, 'f', -1, 64)
+				default:
+					record[j] = fmt.Sprint(v)
+				}
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}).Error
+	w.Flush()
+	if err != nil {
+		log.Println("Failed to export products:", err)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedColumns(t *testing.T) {
+	before := importedFields{name: "Ghế", description: "Gỗ sồi", imageURL: "a.jpg", categoryID: 1, price: 100, stock: 5}
+
+	if columns := changedColumns(before, before); len(columns) != 0 {
+		t.Fatalf("unchanged row: %v", columns)
+	}
+
+	after := before
+	after.price = 120
+	after.name = "Ghế xoay"
+	want := []string{"name", "search_name", "price"}
+	if columns := changedColumns(before, after); !reflect.DeepEqual(columns, want) {
+		t.Fatalf("columns = %v, want %v", columns, want)
+	}
+}
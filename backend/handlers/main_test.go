@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// setupTestDB connects the handlers to the Postgres database in
+// TEST_DATABASE_URL, skipping the test when it is not set. Tests share the
+// database, so they create their own rows and must not rely on it being
+// empty.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	testDB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("Failed to connect to test database:", err)
+	}
+	if err := testDB.AutoMigrate(&models.User{}, &models.Category{}, &models.Product{}, &models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{}, &models.OrderStatusHistory{}, &models.Voucher{}, &models.Blog{}, &models.Session{}, &models.RefreshToken{}, &models.UserToken{}, &models.Permission{}, &models.Role{}, &models.Address{}, &models.Review{}, &models.ProductOption{}, &models.ProductOptionValue{}, &models.ProductVariant{}, &models.Media{}, &models.MediaRendition{}, &models.ProductImage{}, &models.AuditLog{}, &models.Payment{}, &models.WebhookEvent{}, &models.IdempotencyKey{}, &models.Refund{}, &models.RefundItem{}, &models.ReturnRequest{}, &models.ReturnItem{}, &models.ReturnPhoto{}, &models.ReturnEvent{}); err != nil {
+		t.Fatal("Failed to migrate test database:", err)
+	}
+	SetDB(testDB)
+	middleware.SetDB(testDB)
+	t.Cleanup(func() {
+		if sqlDB, err := testDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// asUser authenticates the request as user, the way AuthMiddleware and
+// RequirePermission do.
+func asUser(user *models.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", user.ID)
+		c.Set("user", *user)
+		c.Next()
+	}
+}
+
+func createTestProduct(t *testing.T, price float64, stock int) *models.Product {
+	t.Helper()
+	product := models.Product{Name: "Test product", Price: price, Stock: stock}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Delete(&product)
+	})
+	return &product
+}
+
+func productStock(t *testing.T, productID uint) int {
+	t.Helper()
+	var product models.Product
+	if err := db.Unscoped().First(&product, productID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return product.Stock
+}
+
+// createTestOrder places an order for quantity units of product, taking them
+// out of stock as checkout does.
+func createTestOrder(t *testing.T, user *models.User, product *models.Product, quantity int, status string) *models.Order {
+	t.Helper()
+	total := product.Price * float64(quantity)
+	order := models.Order{
+		UserID:        user.ID,
+		PaymentMethod: stubProviderName,
+		Subtotal:      total,
+		TotalAmount:   total,
+		Status:        status,
+		Items:         []models.OrderItem{{ProductID: product.ID, Quantity: quantity, Price: product.Price}},
+	}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := adjustStock(db, product.ID, nil, -quantity); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		refunds := db.Model(&models.Refund{}).Select("id").Where("order_id = ?", order.ID)
+		payments := db.Model(&models.Payment{}).Select("id").Where("order_id = ?", order.ID)
+		db.Where("refund_id IN (?)", refunds).Delete(&models.RefundItem{})
+		db.Where("order_id = ?", order.ID).Delete(&models.Refund{})
+		db.Where("payment_id IN (?)", payments).Delete(&models.WebhookEvent{})
+		db.Where("order_id = ?", order.ID).Delete(&models.Payment{})
+		db.Where("order_id = ?", order.ID).Delete(&models.OrderStatusHistory{})
+		db.Where("order_id = ?", order.ID).Delete(&models.OrderItem{})
+		db.Delete(&order)
+	})
+	return &order
+}
+
+const stubProviderName = "stub"
+
+// stubProvider is a payment provider for tests. Its notifications are the
+// JSON of a payment.Notification and need no signature. Refunds fail with
+// refundErr when it is set.
+type stubProvider struct {
+	refundErr error
+}
+
+func (p *stubProvider) Name() string { return stubProviderName }
+
+func (p *stubProvider) Checkout(ctx context.Context, req payment.CheckoutRequest) (*payment.Checkout, error) {
+	return &payment.Checkout{}, nil
+}
+
+func (p *stubProvider) ParseNotification(query url.Values, body []byte) (*payment.Notification, error) {
+	var n payment.Notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, payment.ErrInvalidSignature
+	}
+	return &n, nil
+}
+
+func (p *stubProvider) Acknowledge(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *stubProvider) Refund(ctx context.Context, req payment.RefundRequest) (*payment.RefundResult, error) {
+	if p.refundErr != nil {
+		return nil, p.refundErr
+	}
+	return &payment.RefundResult{TransactionID: "STUB" + req.Reference, Message: "Refunded"}, nil
+}
+
+// useStubProvider makes provider the only enabled payment provider for the
+// rest of the test.
+func useStubProvider(t *testing.T, provider *stubProvider) {
+	previous := paymentProviders
+	SetPaymentProviders(map[string]payment.PaymentProvider{stubProviderName: provider})
+	t.Cleanup(func() {
+		SetPaymentProviders(previous)
+	})
+}
+
+// serveJSON sends body as JSON to the router and returns the response.
+func serveJSON(r *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// createPaidPayment records that the whole of order was paid through the
+// stub provider.
+func createPaidPayment(t *testing.T, order *models.Order) *models.Payment {
+	t.Helper()
+	p, err := newPayment(db, order, paymentProviders[stubProviderName])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(p).Update("status", models.PaymentStatusPaid).Error; err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+var store storage.Storage
+
+func SetStorage(s storage.Storage) {
+	store = s
+}
+
+const maxUploadSize = 10 << 20
+
+// maxImagePixels bounds the decoded size of an upload. A small compressed
+// file can declare huge dimensions, and decoding it for renditions would
+// need width × height × 4 bytes of memory.
+const maxImagePixels = 40_000_000
+
+// uploadTypes maps the image types accepted by the media library, as
+// sniffed from the file contents, to the extension they are stored with.
+var uploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var errMediaInUse = errors.New("media is used by a product or blog")
+
+func mediaKey(ext string) (string, error) {
+	return uploadKey("media", ext)
+}
+
+func tooManyPixels(width, height int) bool {
+	return int64(width)*int64(height) > maxImagePixels
+}
+
+// uploadKey returns a random storage key under dir, grouped by month.
+func uploadKey(dir, ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return dir + time.Now().UTC().Format("/2006/01/") + hex.EncodeToString(b) + ext, nil
+}
+
+// --- Media library ---
+func GetMedia(c *gin.Context) {
+	query := db.Model(&models.Media{})
+	if contentType := c.Query("content_type"); contentType != "" {
+		query = query.Where("content_type = ?", contentType)
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("filename ILIKE ? OR alt ILIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+	respondPage(c, query, newestFirst("media", func(m *models.Media) (interface{}, uint) { return m.CreatedAt, m.ID }), "media", preload("Renditions"))
+}
+
+// UploadMedia stores the image sent as the "file" field of a multipart form.
+// The type is sniffed from the contents rather than trusted from the client.
+// Renditions are generated in the background afterwards.
+func UploadMedia(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+1<<20)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", maxUploadSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file field is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if len(data) > maxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", maxUploadSize>>20)})
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := uploadTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG, PNG, GIF and WebP images can be uploaded"})
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is not a valid image"})
+		return
+	}
+	if tooManyPixels(config.Width, config.Height) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Image is larger than %d megapixels", maxImagePixels/1_000_000)})
+		return
+	}
+
+	key, err := mediaKey(ext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+		return
+	}
+	if err := store.Put(c.Request.Context(), key, bytes.NewReader(data), contentType); err != nil {
+		log.Println("Failed to store upload:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+		return
+	}
+
+	checksum := sha256.Sum256(data)
+	userID := c.GetUint("userID")
+	media := models.Media{
+		Key:          key,
+		URL:          store.URL(key),
+		Filename:     filepath.Base(header.Filename),
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		Width:        config.Width,
+		Height:       config.Height,
+		Checksum:     hex.EncodeToString(checksum[:]),
+		Alt:          strings.TrimSpace(c.PostForm("alt")),
+		UploadedByID: &userID,
+	}
+	if err := db.Create(&media).Error; err != nil {
+		store.Delete(c.Request.Context(), key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save media"})
+		return
+	}
+	enqueueRenditions(media.ID)
+	recordAudit(c, models.AuditCreate, "media", media.ID, nil, snapshot(media))
+	c.JSON(http.StatusCreated, media)
+}
+
+func UpdateMedia(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		return
+	}
+	var media models.Media
+	if err := db.Preload("Renditions").First(&media, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+	var mediaData struct {
+		Alt string `json:"alt"`
+	}
+	if err := c.ShouldBindJSON(&mediaData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before := snapshot(media)
+	media.Alt = strings.TrimSpace(mediaData.Alt)
+	if err := db.Save(&media).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update media"})
+		return
+	}
+	recordAudit(c, models.AuditUpdate, "media", media.ID, before, snapshot(media))
+	c.JSON(http.StatusOK, media)
+}
+
+// DeleteMedia removes a file, with its renditions, that no product or blog
+// uses any more.
+func DeleteMedia(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		return
+	}
+	var media models.Media
+	var before auditSnapshot
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Renditions").First(&media, id).Error; err != nil {
+			return err
+		}
+		before = snapshot(media)
+		var productUses, blogUses int64
+		if err := tx.Model(&models.ProductImage{}).Where("media_id = ?", media.ID).Count(&productUses).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Blog{}).Unscoped().Where("image_id = ?", media.ID).Count(&blogUses).Error; err != nil {
+			return err
+		}
+		if productUses+blogUses > 0 {
+			return errMediaInUse
+		}
+		if err := tx.Where("media_id = ?", media.ID).Delete(&models.MediaRendition{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&media).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	} else if errors.Is(err, errMediaInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Media is still used by a product or blog; remove it from there first"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete media"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "media", media.ID, before, nil)
+
+	keys := []string{media.Key}
+	for _, r := range media.Renditions {
+		keys = append(keys, r.Key)
+	}
+	for _, key := range keys {
+		if err := store.Delete(c.Request.Context(), key); err != nil {
+			log.Println("Failed to delete stored file", key+":", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Media deleted successfully"})
+}
+
+// --- Product images ---
+
+// syncProductCover points a product's image_id and image_url at its first
+// gallery image, so listings keep working off a single image.
+func syncProductCover(tx *gorm.DB, productID uint) error {
+	const cover = `SELECT product_images.media_id FROM product_images
+		WHERE product_images.product_id = ? ORDER BY product_images.position, product_images.id LIMIT 1`
+	return tx.Exec(`UPDATE products SET
+		image_id = (`+cover+`),
+		image_url = COALESCE((SELECT url FROM media WHERE id = (`+cover+`)), image_url)
+	WHERE id = ?`, productID, productID, productID).Error
+}
+
+func productImages(productID uint) ([]models.ProductImage, error) {
+	images := []models.ProductImage{}
+	err := db.Scopes(byPosition).Preload("Media.Renditions").Where("product_id = ?", productID).Find(&images).Error
+	return images, err
+}
+
+// AddProductImage appends a media library image to a product's gallery.
+func AddProductImage(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var imageData struct {
+		MediaID uint `json:"media_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&imageData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var media models.Media
+	if err := db.First(&media, imageData.MediaID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown media"})
+		return
+	}
+
+	img := models.ProductImage{ProductID: productID, MediaID: media.ID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", productID).
+			Select("COALESCE(MAX(position) + 1, 0)").Scan(&img.Position).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&img).Error; err != nil {
+			return err
+		}
+		return syncProductCover(tx, productID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add image"})
+		return
+	}
+	recordAudit(c, models.AuditCreate, "product_image", img.ID, nil, snapshot(img))
+	img.Media = media
+	c.JSON(http.StatusCreated, img)
+}
+
+// ReorderProductImages sets the gallery order from a list of every image ID
+// of the product; the first one becomes the cover.
+func ReorderProductImages(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var orderData struct {
+		ImageIDs []uint `json:"image_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&orderData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, err := productImages(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+	current := make(map[uint]bool, len(images))
+	previousOrder := make([]uint, 0, len(images))
+	for _, img := range images {
+		current[img.ID] = true
+		previousOrder = append(previousOrder, img.ID)
+	}
+	seen := make(map[uint]bool, len(orderData.ImageIDs))
+	for _, id := range orderData.ImageIDs {
+		if !current[id] || seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every image of the product exactly once"})
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(current) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every image of the product exactly once"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range orderData.ImageIDs {
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return syncProductCover(tx, productID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+		return
+	}
+	recordAudit(c, models.AuditUpdate, "product", productID,
+		snapshot(gin.H{"image_ids": previousOrder}), snapshot(gin.H{"image_ids": orderData.ImageIDs}))
+	if images, err = productImages(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// DeleteProductImage removes an image from a product's gallery. The file
+// stays in the media library.
+func DeleteProductImage(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var img models.ProductImage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND product_id = ?", imageID, productID).First(&img).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&img).Error; err != nil {
+			return err
+		}
+		return syncProductCover(tx, productID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove image"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "product_image", img.ID, snapshot(img), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Image removed successfully"})
+}
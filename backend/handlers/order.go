@@ -1,69 +1,246 @@
 package handlers
 
 import (
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	errCartEmpty              = errors.New("cart is empty")
+	errInvalidOrderTransition = errors.New("invalid order status transition")
+)
+
+// stockError is returned from the checkout transaction when one or more cart
+// items ask for more units than are left in stock.
+type stockError struct {
+	Items []gin.H
+}
+
+func (e *stockError) Error() string {
+	return "insufficient stock"
+}
+
 func CreateOrder(c *gin.Context) {
 	userID := c.GetUint("userID")
 
-	// Get user's cart
-	var cart models.Cart
-	if err := db.Preload("Items.Product").Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	var checkoutData struct {
+		VoucherCode   string                  `json:"voucher_code"`
+		AddressID     *uint                   `json:"address_id"`
+		Address       *models.ShippingAddress `json:"address"`
+		PaymentMethod string                  `json:"payment_method"`
+	}
+	if err := c.ShouldBindJSON(&checkoutData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	if len(cart.Items) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	if checkoutData.PaymentMethod == "" {
+		checkoutData.PaymentMethod = payment.ProviderCOD
+	}
+	provider, ok := paymentProviders[checkoutData.PaymentMethod]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment method"})
 		return
 	}
 
-	// Calculate total
-	var total float64
-	var orderItems []models.OrderItem
-	for _, item := range cart.Items {
-		orderItems = append(orderItems, models.OrderItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     item.Product.Price,
-		})
-		total += item.Product.Price * float64(item.Quantity)
+	shippingAddress, err := resolveShippingAddress(userID, checkoutData.AddressID, checkoutData.Address)
+	if errors.Is(err, errAddressRequired) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping address is required"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Create order
-	order := models.Order{
-		UserID:      userID,
-		Items:       orderItems,
-		TotalAmount: total,
-		Status:      "pending",
-	}
+	var order models.Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Lock the cart so concurrent checkouts of the same cart serialise
+		var cart models.Cart
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&cart).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errCartEmpty
+			}
+			return err
+		}
+
+		var items []models.CartItem
+		if err := tx.Where("cart_id = ?", cart.ID).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return errCartEmpty
+		}
+
+		// Lock product rows, then variant rows, in a stable order to avoid
+		// deadlocks between concurrent checkouts
+		productIDs := make([]uint, 0, len(items))
+		var variantIDs []uint
+		for _, item := range items {
+			productIDs = append(productIDs, item.ProductID)
+			if item.VariantID != nil {
+				variantIDs = append(variantIDs, *item.VariantID)
+			}
+		}
+		var products []models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", productIDs).Order("id").Find(&products).Error; err != nil {
+			return err
+		}
+		productsByID := make(map[uint]*models.Product, len(products))
+		for i := range products {
+			productsByID[products[i].ID] = &products[i]
+		}
+		variantsByID := map[uint]*models.ProductVariant{}
+		if len(variantIDs) > 0 {
+			var variants []models.ProductVariant
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Preload("OptionValues").Where("id IN ?", variantIDs).Order("id").Find(&variants).Error; err != nil {
+				return err
+			}
+			for i := range variants {
+				variantsByID[variants[i].ID] = &variants[i]
+			}
+		}
+
+		// Check stock for every item before touching anything
+		var shortages []gin.H
+		for _, item := range items {
+			product, ok := productsByID[item.ProductID]
+			var variant *models.ProductVariant
+			if ok && item.VariantID != nil {
+				variant, ok = variantsByID[*item.VariantID]
+				ok = ok && variant.ProductID == product.ID
+			}
+			if !ok {
+				shortages = append(shortages, gin.H{
+					"product_id": item.ProductID,
+					"variant_id": item.VariantID,
+					"requested":  item.Quantity,
+					"available":  0,
+					"error":      "Product is no longer available",
+				})
+				continue
+			}
+			available := product.Stock
+			if variant != nil {
+				available = variant.Stock
+			}
+			if item.Quantity <= 0 || item.Quantity > available {
+				shortages = append(shortages, gin.H{
+					"product_id": product.ID,
+					"variant_id": item.VariantID,
+					"name":       product.Name,
+					"requested":  item.Quantity,
+					"available":  available,
+					"error":      "Insufficient stock",
+				})
+			}
+		}
+		if len(shortages) > 0 {
+			return &stockError{Items: shortages}
+		}
 
-	if err := db.Create(&order).Error; err != nil {
+		// Calculate subtotal and decrement stock
+		var subtotal float64
+		var orderItems []models.OrderItem
+		for _, item := range items {
+			product := productsByID[item.ProductID]
+			orderItem := models.OrderItem{
+				ProductID: product.ID,
+				Quantity:  item.Quantity,
+				Price:     product.Price,
+			}
+			if item.VariantID != nil {
+				variant := variantsByID[*item.VariantID]
+				orderItem.VariantID = &variant.ID
+				orderItem.SKU = variant.SKU
+				orderItem.VariantTitle = variant.Title()
+				orderItem.Price = variant.PriceOr(product.Price)
+			}
+			if err := adjustStock(tx, product.ID, item.VariantID, -item.Quantity); err != nil {
+				return err
+			}
+			orderItems = append(orderItems, orderItem)
+			subtotal += orderItem.Price * float64(item.Quantity)
+		}
+
+		order = models.Order{
+			UserID:          userID,
+			Items:           orderItems,
+			Subtotal:        subtotal,
+			TotalAmount:     subtotal,
+			ShippingAddress: shippingAddress,
+			PaymentMethod:   provider.Name(),
+			Status:          models.OrderStatusPending,
+		}
+
+		if checkoutData.VoucherCode != "" {
+			voucher, discount, err := redeemVoucher(tx, checkoutData.VoucherCode, subtotal)
+			if err != nil {
+				return err
+			}
+			order.VoucherID = &voucher.ID
+			order.VoucherCode = voucher.Code
+			order.DiscountAmount = discount
+			order.TotalAmount = subtotal - discount
+		}
+
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		if err := recordOrderHistory(tx, order.ID, "", models.OrderStatusPending, &userID, "Order placed"); err != nil {
+			return err
+		}
+
+		// Clear cart
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error
+	})
+
+	var stockErr *stockError
+	var voucherErr *voucherError
+	switch {
+	case err == nil:
+	case errors.Is(err, errCartEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	case errors.As(err, &stockErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Some items are out of stock", "items": stockErr.Items})
+		return
+	case errors.As(err, &voucherErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": voucherErr.Error()})
+		return
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
 	}
 
-	// Clear cart
-	if err := db.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
-		// Log error but don't fail the order
+	// The order stands even when the payment cannot be started; the customer
+	// can retry it
+	if _, err := startPayment(c, &order, provider); err != nil {
+		log.Println("Failed to start payment for order", order.ID, "-", err)
 	}
 
+	db.Scopes(preloadOrderItems).Preload("Payments", paymentsByDate).First(&order, order.ID)
 	c.JSON(http.StatusCreated, order)
 }
 
+func orderKey(o *models.Order) (interface{}, uint) {
+	return o.CreatedAt, o.ID
+}
+
 func GetOrders(c *gin.Context) {
 	userID := c.GetUint("userID")
-	var orders []models.Order
-	if err := db.Preload("Items.Product").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
-		return
-	}
-	c.JSON(http.StatusOK, orders)
+	query := db.Model(&models.Order{}).Where("user_id = ?", userID)
+	respondPage(c, query, newestFirst("orders", orderKey), "orders", preloadOrderItems)
 }
 
 func GetOrder(c *gin.Context) {
@@ -71,9 +248,199 @@ func GetOrder(c *gin.Context) {
 	orderID := c.Param("id")
 
 	var order models.Order
-	if err := db.Preload("Items.Product").Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error; err != nil {
+	if err := db.Scopes(preloadOrderItems, preloadRefunds).Preload("History", orderHistoryOrder).Preload("Payments", paymentsByDate).Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// --- Order lifecycle ---
+
+func orderHistoryOrder(tx *gorm.DB) *gorm.DB {
+	return tx.Order("created_at ASC, id ASC")
+}
+
+// preloadOrderItems loads order items with their products, including
+// products moved to the trash since the order was placed.
+func preloadOrderItems(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Items.Product", withDeleted)
+}
+
+func recordOrderHistory(tx *gorm.DB, orderID uint, from, to string, actorID *uint, note string) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+		Note:       note,
+	}).Error
+}
+
+// transitionOrder moves a locked order to status, enforcing the lifecycle
+// rules and recording the change. Cancelling an order puts its items back
+// into stock and gives its voucher use back.
+func transitionOrder(tx *gorm.DB, order *models.Order, status string, actorID *uint, note string) error {
+	if !order.CanTransitionTo(status) {
+		return errInvalidOrderTransition
+	}
+	if status == models.OrderStatusCancelled {
+		if err := restockOrderItems(tx, order.ID); err != nil {
+			return err
+		}
+		if err := releaseVoucher(tx, order); err != nil {
+			return err
+		}
+	}
+	if err := settleOrderPayments(tx, order.ID, status); err != nil {
+		return err
+	}
+	from := order.Status
+	if err := tx.Model(order).Update("status", status).Error; err != nil {
+		return err
+	}
+	return recordOrderHistory(tx, order.ID, from, status, actorID, note)
+}
+
+// restockOrderItems puts the units of an order that are not back in stock
+// yet, e.g. through a refund, back into stock.
+func restockOrderItems(tx *gorm.DB, orderID uint) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := restockOrderItem(tx, &item, item.Quantity-item.RestockedQuantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restockOrderItem(tx *gorm.DB, item *models.OrderItem, quantity int) error {
+	if quantity <= 0 {
+		return nil
+	}
+	if err := adjustStock(tx, item.ProductID, item.VariantID, quantity); err != nil {
+		return err
+	}
+	item.RestockedQuantity += quantity
+	return tx.Model(item).UpdateColumn("restocked_quantity", item.RestockedQuantity).Error
+}
+
+// adjustStock changes the stock of a product, and of the variant when one is
+// given, by delta. A product's stock is the sum of its variants' stock.
+func adjustStock(tx *gorm.DB, productID uint, variantID *uint, delta int) error {
+	if variantID != nil {
+		if err := tx.Model(&models.ProductVariant{}).Where("id = ?", *variantID).
+			UpdateColumn("stock", gorm.Expr("stock + ?", delta)).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).
+		UpdateColumn("stock", gorm.Expr("stock + ?", delta)).Error
+}
+
+// --- Admin order management ---
+
+func GetAllOrders(c *gin.Context) {
+	query := db.Model(&models.Order{})
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected RFC3339"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected RFC3339"})
+			return
+		}
+		query = query.Where("created_at <= ?", t)
+	}
+
+	respondPage(c, query, newestFirst("orders", orderKey), "orders", preloadOrderItems, preload("User", withDeleted))
+}
+
+func GetAdminOrder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	if err := db.Scopes(preloadOrderItems, preloadRefunds).Preload("User", withDeleted).Preload("History", orderHistoryOrder).Preload("Payments", paymentsByDate).First(&order, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 	c.JSON(http.StatusOK, order)
-}
\ No newline at end of file
+}
+
+func UpdateOrderStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var statusData struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&statusData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.IsValidOrderStatus(statusData.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown order status"})
+		return
+	}
+
+	actorID := c.GetUint("userID")
+	var order models.Order
+	var before auditSnapshot
+	var refunds []*models.Refund
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+			return err
+		}
+		before = snapshot(order)
+		if err := transitionOrder(tx, &order, statusData.Status, &actorID, statusData.Note); err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusCancelled {
+			return nil
+		}
+		var err error
+		refunds, err = refundPaidPayments(tx, &order, "Order cancelled", &actorID)
+		return err
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, errInvalidOrderTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot move order from " + order.Status + " to " + statusData.Status})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		return
+	}
+
+	recordAudit(c, models.AuditUpdate, "order", order.ID, before, snapshot(order))
+	processRefunds(c, refunds)
+	db.Scopes(preloadOrderItems, preloadRefunds).Preload("History", orderHistoryOrder).Preload("Payments", paymentsByDate).First(&order, order.ID)
+	c.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Page is the response envelope shared by every list endpoint. Lists can be
+// walked either by page number (page, page_size) or by passing next_cursor
+// back as the cursor query parameter; cursors stay stable while rows are
+// being inserted.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// sortKey is the ordering of a list. Rows are tie-broken by IDColumn in the
+// same direction so that every row has a unique position for cursors.
+type sortKey[T any] struct {
+	Column string
+	// ColumnVars are bound to placeholders when Column is an expression
+	ColumnVars []interface{}
+	IDColumn   string
+	Desc       bool
+	// Key returns the sort column value and the ID of an item
+	Key func(item *T) (interface{}, uint)
+}
+
+// newestFirst orders the rows of table by creation time, newest first.
+func newestFirst[T any](table string, key func(item *T) (interface{}, uint)) sortKey[T] {
+	return sortKey[T]{Column: table + ".created_at", IDColumn: table + ".id", Desc: true, Key: key}
+}
+
+type cursorData struct {
+	Kind  string `json:"k"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func encodeCursor(value interface{}, id uint) string {
+	data := cursorData{ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		data.Kind, data.Value = "t", v.UTC().Format(time.RFC3339Nano)
+	case float64:
+		data.Kind, data.Value = "f", strconv.FormatFloat(v, 'g', -1, 64)
+	case int:
+		data.Kind, data.Value = "i", strconv.Itoa(v)
+	case uint:
+		data.Kind, data.Value = "i", strconv.FormatUint(uint64(v), 10)
+	default:
+		data.Kind, data.Value = "s", fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(data)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (interface{}, uint, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, errInvalidCursor
+	}
+	var data cursorData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, 0, errInvalidCursor
+	}
+
+	var value interface{}
+	switch data.Kind {
+	case "t":
+		value, err = time.Parse(time.RFC3339Nano, data.Value)
+	case "f":
+		value, err = strconv.ParseFloat(data.Value, 64)
+	case "i":
+		value, err = strconv.ParseInt(data.Value, 10, 64)
+	case "s":
+		value = data.Value
+	default:
+		err = errInvalidCursor
+	}
+	if err != nil {
+		return nil, 0, errInvalidCursor
+	}
+	return value, data.ID, nil
+}
+
+func pageSizeParam(c *gin.Context) int {
+	size, err := strconv.Atoi(c.Query("page_size"))
+	if err != nil || size < 1 {
+		return defaultPageSize
+	}
+	if size > maxPageSize {
+		return maxPageSize
+	}
+	return size
+}
+
+// paginate runs query one page at a time according to the page, page_size
+// and cursor query parameters. Scopes, such as preloads, are applied to the
+// item query only and not to the count.
+func paginate[T any](c *gin.Context, query *gorm.DB, key sortKey[T], scopes ...func(*gorm.DB) *gorm.DB) (*Page[T], error) {
+	page := &Page[T]{Items: []T{}, PageSize: pageSizeParam(c)}
+
+	if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	dir, cmp := "ASC", ">"
+	if key.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	itemsQuery := query.Session(&gorm.Session{}).Scopes(scopes...).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                key.Column + " " + dir + ", " + key.IDColumn + " " + dir,
+			Vars:               key.ColumnVars,
+			WithoutParentheses: true,
+		}}).
+		Limit(page.PageSize + 1)
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		value, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		var vars []interface{}
+		vars = append(append(vars, key.ColumnVars...), value)
+		vars = append(append(vars, key.ColumnVars...), value, id)
+		itemsQuery = itemsQuery.Where(
+			"("+key.Column+" "+cmp+" ? OR ("+key.Column+" = ? AND "+key.IDColumn+" "+cmp+" ?))",
+			vars...)
+	} else {
+		page.Page = 1
+		if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 1 {
+			page.Page = p
+		}
+		itemsQuery = itemsQuery.Offset((page.Page - 1) * page.PageSize)
+	}
+
+	if err := itemsQuery.Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Items) > page.PageSize {
+		page.Items = page.Items[:page.PageSize]
+		page.NextCursor = encodeCursor(key.Key(&page.Items[len(page.Items)-1]))
+	}
+	return page, nil
+}
+
+// respondPage paginates query and writes the page as the response.
+func respondPage[T any](c *gin.Context, query *gorm.DB, key sortKey[T], what string, scopes ...func(*gorm.DB) *gorm.DB) {
+	page, err := paginate(c, query, key, scopes...)
+	if errors.Is(err, errInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + what})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func preload(relation string, args ...interface{}) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Preload(relation, args...)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// walkPages lists path page by page, following next_cursor, and returns the
+// IDs in the order they came and the total of the last page. afterFirst runs
+// once the first page has been fetched.
+func walkPages(t *testing.T, r *gin.Engine, path string, afterFirst func()) ([]uint, int64) {
+	t.Helper()
+	var ids []uint
+	var total int64
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("cursors never reached the last page")
+		}
+		target := path
+		if cursor != "" {
+			target += "&cursor=" + url.QueryEscape(cursor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+		}
+		var page Page[struct {
+			ID uint `json:"id"`
+		}]
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		total = page.Total
+		if pages == 0 && afterFirst != nil {
+			afterFirst()
+		}
+		if page.NextCursor == "" {
+			return ids, total
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestCursorsWalkTiedRowsOnce(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "correct horse")
+	product := createTestProduct(t, 100000, 10)
+
+	// Orders placed at the same instant are ordered by ID
+	placedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var want []uint
+	for i := 0; i < 5; i++ {
+		order := createTestOrder(t, user, product, 1, models.OrderStatusPending)
+		db.Model(order).UpdateColumn("created_at", placedAt)
+		want = append([]uint{order.ID}, want...)
+	}
+
+	r := gin.New()
+	r.GET("/orders", asUser(user), GetOrders)
+
+	// An order placed while the list is being walked does not shift it
+	ids, total := walkPages(t, r, "/orders?page_size=2", func() {
+		createTestOrder(t, user, product, 1, models.OrderStatusPending)
+	})
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("walked %v, want %v", ids, want)
+	}
+	if total != 6 {
+		t.Fatalf("total is %d, want 6", total)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders?cursor=not-a-cursor", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+func TestCursorsWalkProductsByPrice(t *testing.T) {
+	setupTestDB(t)
+	category := models.Category{Name: "Test category", Slug: fmt.Sprintf("test-%d", time.Now().UnixNano())}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&category)
+	})
+
+	var want []uint
+	for _, price := range []float64{100000, 100000, 250000, 250000, 250000, 990000} {
+		product := createTestProduct(t, price, 1)
+		db.Model(product).Update("category_id", category.ID)
+		want = append(want, product.ID)
+	}
+
+	r := gin.New()
+	r.GET("/products", GetProducts)
+	path := fmt.Sprintf("/products?category=%d&sort=price&order=asc&page_size=2", category.ID)
+	ids, total := walkPages(t, r, path, nil)
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("walked %v, want %v", ids, want)
+	}
+	if total != int64(len(want)) {
+		t.Fatalf("total is %d, want %d", total, len(want))
+	}
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var paymentProviders map[string]payment.PaymentProvider
+
+func SetPaymentProviders(providers map[string]payment.PaymentProvider) {
+	paymentProviders = providers
+}
+
+var errOrderNotPayable = errors.New("order is not awaiting payment")
+
+// apiURL is the public base URL of this API, which payment gateways send
+// customers and notifications back to.
+func apiURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:8080"
+}
+
+func paymentsByDate(tx *gorm.DB) *gorm.DB {
+	return tx.Order("created_at ASC, id ASC")
+}
+
+// newPayment records a pending payment attempt for an order.
+func newPayment(tx *gorm.DB, order *models.Order, provider payment.PaymentProvider) (*models.Payment, error) {
+	reference, err := payment.NewReference(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	p := models.Payment{
+		OrderID:   order.ID,
+		Provider:  provider.Name(),
+		Reference: reference,
+		Amount:    order.TotalAmount,
+		Status:    models.PaymentStatusPending,
+	}
+	return &p, tx.Create(&p).Error
+}
+
+// startPayment creates a payment for a pending order and starts it at the
+// provider's gateway.
+func startPayment(c *gin.Context, order *models.Order, provider payment.PaymentProvider) (*models.Payment, error) {
+	p, err := newPayment(db, order, provider)
+	if err != nil {
+		return nil, err
+	}
+	return p, checkoutPayment(c, order, provider, p)
+}
+
+// checkoutPayment starts a recorded payment at the provider's gateway. When
+// nothing has to be paid up front, as with cash on delivery, the order is
+// confirmed straight away. A gateway error leaves a failed payment that the
+// customer can retry.
+func checkoutPayment(c *gin.Context, order *models.Order, provider payment.PaymentProvider, p *models.Payment) error {
+	checkout, err := provider.Checkout(c.Request.Context(), payment.CheckoutRequest{
+		Reference:   p.Reference,
+		Amount:      order.TotalAmount,
+		Description: fmt.Sprintf("Payment for order %d", order.ID),
+		ReturnURL:   apiURL() + "/payments/" + provider.Name() + "/return",
+		NotifyURL:   apiURL() + "/payments/" + provider.Name() + "/ipn",
+		ClientIP:    c.ClientIP(),
+		CreatedAt:   p.CreatedAt,
+	})
+	if err != nil {
+		log.Println("Failed to start", provider.Name(), "payment for order", order.ID, "-", err)
+		db.Model(p).Updates(map[string]interface{}{
+			"status":  models.PaymentStatusFailed,
+			"message": "The payment gateway could not be reached",
+		})
+		return nil
+	}
+	if checkout.RedirectURL != "" {
+		return db.Model(p).Update("redirect_url", checkout.RedirectURL).Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var locked models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+			return err
+		}
+		if locked.Status != models.OrderStatusPending {
+			return nil
+		}
+		return transitionOrder(tx, &locked, models.OrderStatusProcessing, nil, "Order confirmed for "+provider.Name()+" payment")
+	})
+}
+
+// applyPaymentNotification records a verified gateway result on its payment
+// and marks the order paid. Results are applied once: notifications for a
+// payment that is already paid change nothing. A payment that arrives for an
+// order that was already paid or cancelled is refunded in full; the pending
+// refund is returned for the caller to send once the transaction commits.
+func applyPaymentNotification(tx *gorm.DB, providerName string, n *payment.Notification) (*models.Payment, *models.Refund, error) {
+	var p models.Payment
+	err := tx.Where("provider = ? AND reference = ?", providerName, n.Reference).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, payment.ErrUnknownPayment
+	} else if err != nil {
+		return nil, nil, err
+	}
+	// The order is locked before its payments, as everywhere else, so that
+	// the payments of one order are applied one at a time
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, p.OrderID).Error; err != nil {
+		return &p, nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, p.ID).Error; err != nil {
+		return &p, nil, err
+	}
+	if math.Round(p.Amount) != math.Round(n.Amount) {
+		return &p, nil, payment.ErrAmountMismatch
+	}
+	if p.Status == models.PaymentStatusPaid || p.Status == models.PaymentStatusRefunded || n.Status == payment.StatusPending {
+		return &p, nil, nil
+	}
+
+	if n.Status != payment.StatusPaid {
+		if p.Status != models.PaymentStatusPending {
+			return &p, nil, nil
+		}
+		return &p, nil, tx.Model(&p).Updates(map[string]interface{}{
+			"status":         models.PaymentStatusFailed,
+			"transaction_id": n.TransactionID,
+			"message":        n.Message,
+		}).Error
+	}
+
+	now := time.Now()
+	if err := tx.Model(&p).Updates(map[string]interface{}{
+		"status":         models.PaymentStatusPaid,
+		"transaction_id": n.TransactionID,
+		"message":        n.Message,
+		"paid_at":        now,
+	}).Error; err != nil {
+		return &p, nil, err
+	}
+
+	var paidBefore int64
+	if err := tx.Model(&models.Payment{}).
+		Where("order_id = ? AND id <> ? AND status IN ?", order.ID, p.ID, []string{models.PaymentStatusPaid, models.PaymentStatusRefunded}).
+		Count(&paidBefore).Error; err != nil {
+		return &p, nil, err
+	}
+	if paidBefore > 0 || order.Status == models.OrderStatusCancelled {
+		refund, err := refundLatePayment(tx, &order, &p, paidBefore > 0)
+		return &p, refund, err
+	}
+
+	// Other attempts still open are not needed any more
+	if err := tx.Model(&models.Payment{}).Where("order_id = ? AND id <> ? AND status = ?", order.ID, p.ID, models.PaymentStatusPending).
+		Updates(map[string]interface{}{
+			"status":  models.PaymentStatusCancelled,
+			"message": "The order was paid with another payment",
+		}).Error; err != nil {
+		return &p, nil, err
+	}
+	if order.Status != models.OrderStatusPending {
+		return &p, nil, nil
+	}
+	return &p, nil, transitionOrder(tx, &order, models.OrderStatusProcessing, nil, "Paid with "+providerName)
+}
+
+// refundLatePayment refunds the whole of a payment the order did not need:
+// either another payment had already paid for it, or it was cancelled before
+// the payment arrived.
+func refundLatePayment(tx *gorm.DB, order *models.Order, p *models.Payment, overpayment bool) (*models.Refund, error) {
+	reason := "The order was cancelled before the payment arrived"
+	if overpayment {
+		reason = "The order was already paid with another payment"
+	}
+	refund, err := createRefund(tx, order, p, p.Amount, reason, nil, nil)
+	if err != nil || !overpayment {
+		return refund, err
+	}
+	refund.Overpayment = true
+	return refund, tx.Model(refund).UpdateColumn("overpayment", true).Error
+}
+
+// settleOrderPayments brings the payments of an order in line with its new
+// status: cash on delivery is collected on delivery, and attempts still
+// open when an order is cancelled are cancelled with it.
+func settleOrderPayments(tx *gorm.DB, orderID uint, status string) error {
+	pending := tx.Model(&models.Payment{}).Where("order_id = ? AND status = ?", orderID, models.PaymentStatusPending)
+	switch status {
+	case models.OrderStatusDelivered:
+		return pending.Where("provider = ?", payment.ProviderCOD).Updates(map[string]interface{}{
+			"status":  models.PaymentStatusPaid,
+			"paid_at": time.Now(),
+		}).Error
+	case models.OrderStatusCancelled:
+		return pending.Update("status", models.PaymentStatusCancelled).Error
+	}
+	return nil
+}
+
+func GetPaymentMethods(c *gin.Context) {
+	methods := make([]string, 0, len(paymentProviders))
+	for name := range paymentProviders {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	c.JSON(http.StatusOK, gin.H{"methods": methods})
+}
+
+// PayOrder starts a new payment attempt for one of the customer's pending
+// orders, e.g. after a failed or abandoned one or to switch method. Attempts
+// still open are cancelled first, so that only the newest one can be paid.
+func PayOrder(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var payData struct {
+		PaymentMethod string `json:"payment_method"`
+	}
+	if err := c.ShouldBindJSON(&payData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	method := order.PaymentMethod
+	if payData.PaymentMethod != "" {
+		method = payData.PaymentMethod
+	}
+	provider, ok := paymentProviders[method]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment method"})
+		return
+	}
+
+	// The order stays locked until the new attempt exists, so that
+	// concurrent requests cannot leave two attempts open
+	var p *models.Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, order.ID).Error; err != nil {
+			return err
+		}
+		var paid int64
+		if err := tx.Model(&models.Payment{}).Where("order_id = ? AND status = ?", order.ID, models.PaymentStatusPaid).
+			Count(&paid).Error; err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusPending || paid > 0 {
+			return errOrderNotPayable
+		}
+		if err := tx.Model(&models.Payment{}).Where("order_id = ? AND status = ?", order.ID, models.PaymentStatusPending).
+			Updates(map[string]interface{}{
+				"status":  models.PaymentStatusCancelled,
+				"message": "Replaced by a new payment attempt",
+			}).Error; err != nil {
+			return err
+		}
+		if method != order.PaymentMethod {
+			if err := tx.Model(&order).Update("payment_method", method).Error; err != nil {
+				return err
+			}
+		}
+		var err error
+		p, err = newPayment(tx, &order, provider)
+		return err
+	})
+	if errors.Is(err, errOrderNotPayable) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is not awaiting payment"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start payment"})
+		return
+	}
+
+	if err := checkoutPayment(c, &order, provider, p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start payment"})
+		return
+	}
+	db.First(p, p.ID)
+	c.JSON(http.StatusCreated, p)
+}
+
+// PaymentReturn handles customers coming back from a gateway and sends them
+// on to the order page of the storefront.
+func PaymentReturn(c *gin.Context) {
+	provider, ok := paymentProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment method"})
+		return
+	}
+	event, err := receiveWebhook(c, provider, models.WebhookSourceReturn, nil)
+	if err != nil {
+		log.Println("Rejected", provider.Name(), "payment return -", err)
+	}
+	var p models.Payment
+	if event == nil || event.PaymentID == nil || db.First(&p, *event.PaymentID).Error != nil {
+		c.Redirect(http.StatusSeeOther, appURL()+"/orders?payment=invalid")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("%s/orders/%d?payment=%s", appURL(), p.OrderID, strings.ToLower(p.Status)))
+}
+
+// PaymentNotify handles a gateway's server-to-server notification (IPN).
+// Every notification is stored as a webhook event before it is processed.
+func PaymentNotify(c *gin.Context) {
+	provider, ok := paymentProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment method"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read notification"})
+		return
+	}
+	if _, err = receiveWebhook(c, provider, models.WebhookSourceIPN, body); err != nil {
+		log.Println("Rejected", provider.Name(), "payment notification -", err)
+	}
+	provider.Acknowledge(c.Writer, err)
+}
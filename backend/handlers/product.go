@@ -1,63 +1,168 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"ecommerce-backend/models"
+	"ecommerce-backend/search"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func GetProducts(c *gin.Context) {
-	var products []models.Product
+const searchSnippetLength = 160
 
-	query := db.Model(&models.Product{})
+// productFilter is one filter of the product listing. Facet names the facet
+// the filter belongs to, so that facet counts can ignore their own filter.
+type productFilter struct {
+	facet string
+	apply func(*gorm.DB) *gorm.DB
+}
 
-	// Category filter
+// productFilters reads the listing filters from the query string.
+func productFilters(c *gin.Context) ([]productFilter, search.Query) {
+	var filters []productFilter
+
+	// Category filter, by slug or ID, including every descendant category.
+	// "Tất cả" (all) is what the storefront sends when no category is chosen.
 	if category := c.Query("category"); category != "" && category != "Tất cả" {
-		query = query.Where("category = ?", category)
+		filters = append(filters, productFilter{"category", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("products.category_id IN (?)", categorySubtree(category))
+		}})
 	}
 
 	// Price range filter
-	if minPrice := c.Query("min_price"); minPrice != "" {
-		if min, err := strconv.Atoi(minPrice); err == nil {
-			query = query.Where("price >= ?", min)
-		}
+	if min, err := strconv.ParseFloat(c.Query("min_price"), 64); err == nil {
+		filters = append(filters, productFilter{"price", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("products.price >= ?", min)
+		}})
 	}
-	if maxPrice := c.Query("max_price"); maxPrice != "" {
-		if max, err := strconv.Atoi(maxPrice); err == nil {
-			query = query.Where("price <= ?", max)
-		}
+	if max, err := strconv.ParseFloat(c.Query("max_price"), 64); err == nil {
+		filters = append(filters, productFilter{"price", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("products.price <= ?", max)
+		}})
+	}
+
+	// Availability filter
+	if inStock, err := strconv.ParseBool(c.Query("in_stock")); err == nil {
+		filters = append(filters, productFilter{"availability", func(tx *gorm.DB) *gorm.DB {
+			if inStock {
+				return tx.Where("products.stock > 0")
+			}
+			return tx.Where("products.stock <= 0")
+		}})
+	}
+
+	// Rating filter
+	if minRating, err := strconv.ParseFloat(c.Query("min_rating"), 64); err == nil {
+		filters = append(filters, productFilter{"rating", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("products.rating >= ?", minRating)
+		}})
+	}
+
+	// Attribute filters such as attr[Màu sắc]=Đen,Xám
+	for name, values := range c.QueryMap("attr") {
+		name, values := name, strings.Split(values, ",")
+		filters = append(filters, productFilter{"attr:" + name, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where(`EXISTS (SELECT 1 FROM product_options
+				JOIN product_option_values ON product_option_values.option_id = product_options.id
+				WHERE product_options.product_id = products.id AND product_options.name = ? AND product_option_values.value IN ?)`,
+				name, values)
+		}})
 	}
 
 	// Search filter
-	if search := c.Query("search"); search != "" {
-		query = query.Where("name ILIKE ? OR description ILIKE ?", "%"+search+"%", "%"+search+"%")
+	searchQuery := search.Parse(c.Query("search"))
+	if !searchQuery.Empty() {
+		filters = append(filters, productFilter{"search", searchQuery.Filter})
 	}
 
-	// Sorting
-	sortBy := c.DefaultQuery("sort", "created_at")
+	return filters, searchQuery
+}
+
+// filteredProducts applies every filter except those of the given facet.
+func filteredProducts(filters []productFilter, exceptFacet string) *gorm.DB {
+	query := db.Model(&models.Product{})
+	for _, filter := range filters {
+		if filter.facet != exceptFacet {
+			query = filter.apply(query)
+		}
+	}
+	return query
+}
+
+func GetProducts(c *gin.Context) {
+	filters, searchQuery := productFilters(c)
+	query := filteredProducts(filters, "")
+
+	// Sorting, by relevance by default when searching
+	defaultSort := "created_at"
+	if !searchQuery.Empty() {
+		defaultSort = "relevance"
+	}
+	sortBy := c.DefaultQuery("sort", defaultSort)
 	order := c.DefaultQuery("order", "desc")
 
+	scopes := []func(*gorm.DB) *gorm.DB{preload("Category"), preload("Image.Renditions")}
+	var key sortKey[models.Product]
 	switch sortBy {
-	case "price":
-		if order == "asc" {
-			query = query.Order("price ASC")
-		} else {
-			query = query.Order("price DESC")
+	case "relevance":
+		if searchQuery.Empty() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sorting by relevance requires a search query"})
+			return
 		}
+		rank := searchQuery.Rank()
+		key = sortKey[models.Product]{Column: rank.SQL, ColumnVars: rank.Vars, IDColumn: "products.id", Desc: true,
+			Key: func(p *models.Product) (interface{}, uint) { return p.SearchRank, p.ID }}
+		scopes = append(scopes, func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("products.*, ? AS search_rank", rank)
+		})
+	case "price":
+		key = sortKey[models.Product]{Column: "products.price", IDColumn: "products.id", Desc: order != "asc",
+			Key: func(p *models.Product) (interface{}, uint) { return p.Price, p.ID }}
 	case "name":
-		query = query.Order("name ASC")
+		key = sortKey[models.Product]{Column: "products.name", IDColumn: "products.id",
+			Key: func(p *models.Product) (interface{}, uint) { return p.Name, p.ID }}
 	default: // created_at or newest
-		query = query.Order("created_at DESC")
+		key = newestFirst("products", func(p *models.Product) (interface{}, uint) { return p.CreatedAt, p.ID })
 	}
 
-	if err := query.Find(&products).Error; err != nil {
+	page, err := paginate(c, query, key, scopes...)
+	if errors.Is(err, errInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
-	c.JSON(http.StatusOK, products)
+
+	// Record storefront searches once per search rather than per page, and
+	// leave out staff browsing the admin catalogue
+	if _, staff := c.Get("user"); !searchQuery.Empty() && page.Page == 1 && !staff {
+		recordSearch(searchQuery, page.Total)
+	}
+
+	if !searchQuery.Empty() {
+		for i := range page.Items {
+			page.Items[i].Highlight = &models.SearchHighlight{
+				Name:    search.Highlight(page.Items[i].Name, searchQuery.Terms, 0),
+				Snippet: search.Highlight(page.Items[i].Description, searchQuery.Terms, searchSnippetLength),
+			}
+		}
+	}
+
+	response := productPage{Page: page}
+	if withFacets, _ := strconv.ParseBool(c.Query("facets")); withFacets {
+		if response.Facets, err = productFacets(filters); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute facets"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func GetProduct(c *gin.Context) {
@@ -68,7 +173,7 @@ func GetProduct(c *gin.Context) {
 	}
 
 	var product models.Product
-	if err := db.First(&product, id).Error; err != nil {
+	if err := db.Scopes(preloadVariants).Preload("Images", byPosition).Preload("Images.Media.Renditions").Preload("Image.Renditions").Preload("Category").Preload("Reviews", approvedReviews).Preload("Reviews.User").First(&product, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
@@ -81,11 +186,19 @@ func CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if product.CategoryID != nil && !categoryExists(*product.CategoryID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category"})
+		return
+	}
 
-	if err := db.Create(&product).Error; err != nil {
+	if err := db.Omit(clause.Associations).Create(&product).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU is already in use"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
+	recordAudit(c, models.AuditCreate, "product", product.ID, nil, snapshot(product))
 	c.JSON(http.StatusCreated, product)
 }
 
@@ -101,16 +214,33 @@ func UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	before := snapshot(product)
 
 	if err := c.ShouldBindJSON(&product); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if product.CategoryID != nil && !categoryExists(*product.CategoryID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category"})
+		return
+	}
 
-	if err := db.Save(&product).Error; err != nil {
+	if err := db.Omit(clause.Associations).Save(&product).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU is already in use"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
+
+	// Stock of a product sold in variants is always the sum of its variants
+	var variantCount int64
+	db.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variantCount)
+	if variantCount > 0 {
+		syncProductStock(db, product.ID)
+		db.First(&product, product.ID)
+	}
+	recordAudit(c, models.AuditUpdate, "product", product.ID, before, snapshot(product))
 	c.JSON(http.StatusOK, product)
 }
 
@@ -121,9 +251,27 @@ func DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := db.Unscoped().Delete(&models.Product{}, id).Error; err != nil {
+	// Deleted products move to the trash; they can no longer be bought, so
+	// they leave every cart
+	var product models.Product
+	var before auditSnapshot
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&product, id).Error; err != nil {
+			return err
+		}
+		before = snapshot(product)
+		if err := tx.Delete(&product).Error; err != nil {
+			return err
+		}
+		return tx.Where("product_id = ?", id).Delete(&models.CartItem{}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+	recordAudit(c, models.AuditDelete, "product", product.ID, before, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Product moved to trash"})
 }
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refundError explains why a refund cannot be made.
+type refundError struct {
+	message string
+}
+
+func (e *refundError) Error() string {
+	return e.message
+}
+
+var (
+	errNoRefundablePayment = errors.New("order has no paid payment to refund")
+	errOrderNotCancellable = errors.New("order can no longer be cancelled")
+	errRefundNotRetryable  = errors.New("only failed or stuck refunds can be retried")
+	errRefundNotCancelable = errors.New("only failed refunds can be cancelled")
+)
+
+// refundPendingTimeout is how long a refund may stay pending before it is
+// taken to be stuck, e.g. because its outcome could not be recorded, and
+// may be retried.
+const refundPendingTimeout = 10 * time.Minute
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// releaseVoucher gives the voucher use of a cancelled or fully refunded
+// order back, once.
+func releaseVoucher(tx *gorm.DB, order *models.Order) error {
+	if order.VoucherID == nil || order.VoucherReleased {
+		return nil
+	}
+	if err := tx.Unscoped().Model(&models.Voucher{}).Where("id = ? AND used_count > 0", *order.VoucherID).
+		UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+		return err
+	}
+	order.VoucherReleased = true
+	return tx.Model(order).UpdateColumn("voucher_released", true).Error
+}
+
+// refundableAmount is what is left of a payment after its pending and
+// succeeded refunds.
+func refundableAmount(tx *gorm.DB, p *models.Payment) (float64, error) {
+	var refunded float64
+	err := tx.Model(&models.Refund{}).
+		Where("payment_id = ? AND status IN ?", p.ID, []string{models.RefundStatusPending, models.RefundStatusSucceeded}).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error
+	return roundMoney(p.Amount - refunded), err
+}
+
+// refundablePayment locks the paid payments of an order and picks the one
+// amount can be refunded from, preferring the given payment.
+func refundablePayment(tx *gorm.DB, orderID uint, paymentID *uint, amount float64) (*models.Payment, error) {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", orderID, models.PaymentStatusPaid)
+	if paymentID != nil {
+		query = query.Where("id = ?", *paymentID)
+	}
+	var payments []models.Payment
+	if err := query.Order("id").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, errNoRefundablePayment
+	}
+	var most float64
+	for i := range payments {
+		available, err := refundableAmount(tx, &payments[i])
+		if err != nil {
+			return nil, err
+		}
+		if available >= amount {
+			return &payments[i], nil
+		}
+		most = math.Max(most, available)
+	}
+	return nil, &refundError{fmt.Sprintf("At most %.2f can be refunded from a single payment", most)}
+}
+
+// createRefund records a pending refund of a locked payment. The gateway is
+// only called by processRefund, once the transaction is committed.
+func createRefund(tx *gorm.DB, order *models.Order, p *models.Payment, amount float64, reason string, actorID *uint, items []models.RefundItem) (*models.Refund, error) {
+	reference, err := payment.NewRefundReference(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	refund := models.Refund{
+		OrderID:   order.ID,
+		PaymentID: p.ID,
+		Provider:  p.Provider,
+		Reference: reference,
+		Amount:    amount,
+		Reason:    reason,
+		Status:    models.RefundStatusPending,
+		ActorID:   actorID,
+		Items:     items,
+	}
+	return &refund, tx.Create(&refund).Error
+}
+
+// refundPaidPayments refunds whatever is left of the paid payments of a
+// cancelled order.
+func refundPaidPayments(tx *gorm.DB, order *models.Order, reason string, actorID *uint) ([]*models.Refund, error) {
+	var payments []models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", order.ID, models.PaymentStatusPaid).
+		Order("id").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	var refunds []*models.Refund
+	for i := range payments {
+		available, err := refundableAmount(tx, &payments[i])
+		if err != nil {
+			return nil, err
+		}
+		if available <= 0 {
+			continue
+		}
+		refund, err := createRefund(tx, order, &payments[i], available, reason, actorID, nil)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, nil
+}
+
+// refundLine asks for a quantity of an order item to be refunded.
+type refundLine struct {
+	OrderItemID uint `json:"order_item_id" binding:"required"`
+	Quantity    int  `json:"quantity" binding:"required,min=1"`
+	Restock     bool `json:"restock"`
+}
+
+// refundOrderItems marks quantities of the items of a locked order as
+// refunded, so they can't be refunded twice. It returns the refund items and
+// their total; items are refunded at their share of the order total after
+// discount. Stock and the voucher use are only given back by completeRefund,
+// once the money is.
+func refundOrderItems(tx *gorm.DB, order *models.Order, lines []refundLine) ([]models.RefundItem, float64, error) {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, 0, err
+	}
+	itemsByID := make(map[uint]*models.OrderItem, len(items))
+	for i := range items {
+		itemsByID[items[i].ID] = &items[i]
+	}
+
+	share := 1.0
+	if order.Subtotal > 0 {
+		share = order.TotalAmount / order.Subtotal
+	}
+	var refundItems []models.RefundItem
+	var total float64
+	seen := map[uint]bool{}
+	for _, line := range lines {
+		item, ok := itemsByID[line.OrderItemID]
+		if !ok || seen[line.OrderItemID] {
+			return nil, 0, &refundError{fmt.Sprintf("Order item %d is not part of the order or is listed twice", line.OrderItemID)}
+		}
+		seen[line.OrderItemID] = true
+		if left := item.Quantity - item.RefundedQuantity; line.Quantity > left {
+			return nil, 0, &refundError{fmt.Sprintf("Only %d of order item %d can still be refunded", left, item.ID)}
+		}
+		if line.Restock && line.Quantity > item.Quantity-item.RestockedQuantity {
+			return nil, 0, &refundError{fmt.Sprintf("Order item %d is already back in stock", item.ID)}
+		}
+
+		item.RefundedQuantity += line.Quantity
+		if err := tx.Model(item).UpdateColumn("refunded_quantity", item.RefundedQuantity).Error; err != nil {
+			return nil, 0, err
+		}
+		amount := roundMoney(item.Price * float64(line.Quantity) * share)
+		total += amount
+		refundItems = append(refundItems, models.RefundItem{
+			OrderItemID: item.ID,
+			Quantity:    line.Quantity,
+			Amount:      amount,
+			Restock:     line.Restock,
+		})
+	}
+
+	return refundItems, roundMoney(total), nil
+}
+
+// completeRefund puts the items of a succeeded refund back into stock when
+// asked, and gives the voucher use of an order refunded in full back. The
+// order must be locked.
+func completeRefund(tx *gorm.DB, order *models.Order, refund *models.Refund) error {
+	var refundItems []models.RefundItem
+	if err := tx.Where("refund_id = ?", refund.ID).Find(&refundItems).Error; err != nil {
+		return err
+	}
+	if len(refundItems) == 0 {
+		return nil
+	}
+	for _, refundItem := range refundItems {
+		if !refundItem.Restock {
+			continue
+		}
+		var item models.OrderItem
+		if err := tx.First(&item, refundItem.OrderItemID).Error; err != nil {
+			return err
+		}
+		// Units restocked meanwhile, e.g. by a cancellation, are not
+		// restocked twice
+		quantity := refundItem.Quantity
+		if left := item.Quantity - item.RestockedQuantity; quantity > left {
+			quantity = left
+		}
+		if err := restockOrderItem(tx, &item, quantity); err != nil {
+			return err
+		}
+	}
+
+	var outstanding int64
+	if err := tx.Model(&models.OrderItem{}).Where("order_id = ? AND refunded_quantity < quantity", order.ID).
+		Count(&outstanding).Error; err != nil || outstanding > 0 {
+		return err
+	}
+	return releaseVoucher(tx, order)
+}
+
+// releaseRefundItems makes the items of a refund that will not be paid
+// refundable again.
+func releaseRefundItems(tx *gorm.DB, refund *models.Refund) error {
+	var refundItems []models.RefundItem
+	if err := tx.Where("refund_id = ?", refund.ID).Find(&refundItems).Error; err != nil {
+		return err
+	}
+	for _, refundItem := range refundItems {
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", refundItem.OrderItemID).
+			UpdateColumn("refunded_quantity", gorm.Expr("refunded_quantity - ?", refundItem.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processRefund sends a pending refund requested by the current user to its
+// gateway.
+func processRefund(c *gin.Context, refund *models.Refund) error {
+	requestedBy := strconv.FormatUint(uint64(c.GetUint("userID")), 10)
+	if user, ok := c.Get("user"); ok {
+		requestedBy = user.(models.User).Email
+	}
+	// The refund must go through even if the client goes away
+	return sendRefund(context.WithoutCancel(c.Request.Context()), refund, requestedBy, c.ClientIP())
+}
+
+// sendRefund sends a pending refund to its gateway and records the outcome.
+// A refund the gateway did not accept is marked failed and can be retried
+// or cancelled from the admin area. One whose outcome could not be recorded
+// stays pending and can be retried once refundPendingTimeout has passed.
+func sendRefund(ctx context.Context, refund *models.Refund, requestedBy, clientIP string) error {
+	var p models.Payment
+	if err := db.First(&p, refund.PaymentID).Error; err != nil {
+		return failRefund(refund, err)
+	}
+	provider, ok := paymentProviders[refund.Provider]
+	if !ok {
+		return failRefund(refund, fmt.Errorf("payment provider %s is not enabled", refund.Provider))
+	}
+	result, err := provider.Refund(ctx, payment.RefundRequest{
+		Reference:        refund.Reference,
+		Amount:           refund.Amount,
+		Reason:           refund.Reason,
+		PaymentReference: p.Reference,
+		TransactionID:    p.TransactionID,
+		PaymentAmount:    p.Amount,
+		PaymentCreatedAt: p.CreatedAt,
+		RequestedBy:      requestedBy,
+		ClientIP:         clientIP,
+	})
+	if err != nil {
+		log.Println("Failed to refund", refund.Reference, "with", refund.Provider, "-", err)
+		return failRefund(refund, err)
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, refund.OrderID).Error; err != nil {
+			return err
+		}
+		// A retry of a stuck refund may already have recorded it
+		recorded := tx.Model(refund).Where("status = ?", models.RefundStatusPending).Updates(map[string]interface{}{
+			"status":         models.RefundStatusSucceeded,
+			"transaction_id": result.TransactionID,
+			"message":        result.Message,
+			"refunded_at":    now,
+		})
+		if recorded.Error != nil || recorded.RowsAffected == 0 {
+			return recorded.Error
+		}
+		if err := completeRefund(tx, &order, refund); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Payment{}).Where("id = ?", p.ID).
+			UpdateColumn("refunded_amount", gorm.Expr("refunded_amount + ?", refund.Amount)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Payment{}).Where("id = ? AND refunded_amount >= amount - 0.005", p.ID).
+			Update("status", models.PaymentStatusRefunded).Error; err != nil {
+			return err
+		}
+		if refund.Overpayment {
+			return nil
+		}
+		return tx.Model(&models.Order{}).Where("id = ?", refund.OrderID).
+			UpdateColumn("refunded_amount", gorm.Expr("refunded_amount + ?", refund.Amount)).Error
+	})
+	if err != nil {
+		log.Println("Failed to record refund", refund.Reference, "which stays pending -", err)
+	}
+	return err
+}
+
+func failRefund(refund *models.Refund, cause error) error {
+	refund.Status = models.RefundStatusFailed
+	refund.Message = cause.Error()
+	db.Model(refund).Updates(map[string]interface{}{
+		"status":  refund.Status,
+		"message": refund.Message,
+	})
+	return cause
+}
+
+// processRefunds processes refunds made by a cancellation; failures are
+// left for an admin to retry.
+func processRefunds(c *gin.Context, refunds []*models.Refund) {
+	for _, refund := range refunds {
+		processRefund(c, refund)
+	}
+}
+
+func preloadRefunds(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Refunds", paymentsByDate).Preload("Refunds.Items")
+}
+
+// CancelOrder lets customers cancel their orders until they ship. Whatever
+// was paid is refunded.
+func CancelOrder(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var cancelData struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&cancelData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	var refunds []*models.Refund
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusProcessing {
+			return errOrderNotCancellable
+		}
+		note := "Cancelled by the customer"
+		if cancelData.Reason != "" {
+			note += ": " + cancelData.Reason
+		}
+		if err := transitionOrder(tx, &order, models.OrderStatusCancelled, &userID, note); err != nil {
+			return err
+		}
+		var err error
+		refunds, err = refundPaidPayments(tx, &order, "Order cancelled by the customer", &userID)
+		return err
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, errOrderNotCancellable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has already shipped and can no longer be cancelled"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+		return
+	}
+
+	processRefunds(c, refunds)
+	db.Scopes(preloadOrderItems, preloadRefunds).Preload("History", orderHistoryOrder).Preload("Payments", paymentsByDate).First(&order, order.ID)
+	c.JSON(http.StatusOK, order)
+}
+
+// --- Admin refunds ---
+
+func GetRefunds(c *gin.Context) {
+	query := db.Model(&models.Refund{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+	if provider := c.Query("provider"); provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+	key := newestFirst("refunds", func(r *models.Refund) (interface{}, uint) { return r.CreatedAt, r.ID })
+	respondPage(c, query, key, "refunds", preload("Items"))
+}
+
+// CreateRefund refunds items of an order, an amount, or both. Items are
+// refunded at their share of the order total after discount, and may be put
+// back into stock once the refund succeeds. The amount, when given, replaces
+// the items' total, e.g. to keep back the shipping or to add a goodwill
+// gesture.
+func CreateRefund(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var refundData struct {
+		Items     []refundLine `json:"items" binding:"dive"`
+		Amount    *float64     `json:"amount"`
+		PaymentID *uint        `json:"payment_id"`
+		Reason    string       `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&refundData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(refundData.Items) == 0 && refundData.Amount == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give the items to refund, an amount or both"})
+		return
+	}
+	if refundData.Amount != nil && roundMoney(*refundData.Amount) <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be positive"})
+		return
+	}
+
+	actorID := c.GetUint("userID")
+	var refund *models.Refund
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+			return err
+		}
+		refundItems, itemsAmount, err := refundOrderItems(tx, &order, refundData.Items)
+		if err != nil {
+			return err
+		}
+
+		amount := itemsAmount
+		if refundData.Amount != nil {
+			amount = roundMoney(*refundData.Amount)
+		}
+		p, err := refundablePayment(tx, order.ID, refundData.PaymentID, amount)
+		if err != nil {
+			return err
+		}
+		refund, err = createRefund(tx, &order, p, amount, refundData.Reason, &actorID, refundItems)
+		return err
+	})
+	var refundErr *refundError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.As(err, &refundErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": refundErr.message})
+		return
+	case errors.Is(err, errNoRefundablePayment):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has no paid payment to refund"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
+		return
+	}
+
+	recordAudit(c, models.AuditCreate, "refund", refund.ID, nil, snapshot(refund))
+	respondRefund(c, refund, http.StatusCreated)
+}
+
+// RetryRefund sends a failed refund to its gateway again, or a stuck pending
+// one. A stuck refund is sent with the same reference, so a gateway that
+// already made it does not pay it twice.
+func RetryRefund(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	var refund models.Refund
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&refund, id).Error; err != nil {
+			return err
+		}
+		stuck := refund.Status == models.RefundStatusPending && refund.UpdatedAt.Before(time.Now().Add(-refundPendingTimeout))
+		if refund.Status != models.RefundStatusFailed && !stuck {
+			return errRefundNotRetryable
+		}
+		if refund.Status == models.RefundStatusFailed {
+			var p models.Payment
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, refund.PaymentID).Error; err != nil {
+				return err
+			}
+			available, err := refundableAmount(tx, &p)
+			if err != nil {
+				return err
+			}
+			if available < refund.Amount {
+				return &refundError{fmt.Sprintf("Only %.2f of the payment is left to refund", available)}
+			}
+		}
+		// Saving also moves updated_at on, so that a stuck refund is only
+		// retried once at a time
+		refund.Status = models.RefundStatusPending
+		return tx.Model(&refund).Update("status", refund.Status).Error
+	})
+	var refundErr *refundError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return
+	case errors.Is(err, errRefundNotRetryable):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only failed refunds, or refunds pending for over %v, can be retried", refundPendingTimeout)})
+		return
+	case errors.As(err, &refundErr):
+		c.JSON(http.StatusConflict, gin.H{"error": refundErr.message})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry refund"})
+		return
+	}
+
+	recordAudit(c, models.AuditRetry, "refund", refund.ID, nil, nil)
+	respondRefund(c, &refund, http.StatusOK)
+}
+
+// CancelRefund gives up on a failed refund. Its items become refundable
+// again; nothing was restocked for them, as that only happens on success.
+func CancelRefund(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	var refund models.Refund
+	var before auditSnapshot
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&refund, id).Error; err != nil {
+			return err
+		}
+		// The order is locked first, as everywhere else
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, refund.OrderID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&refund, id).Error; err != nil {
+			return err
+		}
+		if refund.Status != models.RefundStatusFailed {
+			return errRefundNotCancelable
+		}
+		before = snapshot(&refund)
+		if err := releaseRefundItems(tx, &refund); err != nil {
+			return err
+		}
+		refund.Status = models.RefundStatusCancelled
+		return tx.Model(&refund).Update("status", refund.Status).Error
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return
+	case errors.Is(err, errRefundNotCancelable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed refunds can be cancelled"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel refund"})
+		return
+	}
+
+	db.Preload("Items").First(&refund, refund.ID)
+	recordAudit(c, models.AuditCancel, "refund", refund.ID, before, snapshot(&refund))
+	c.JSON(http.StatusOK, refund)
+}
+
+// respondRefund processes a refund and answers with it, or with 502 when
+// the gateway did not accept it.
+func respondRefund(c *gin.Context, refund *models.Refund, status int) {
+	err := processRefund(c, refund)
+	db.Preload("Items").First(refund, refund.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The payment provider did not accept the refund", "refund": refund})
+		return
+	}
+	c.JSON(status, refund)
+}
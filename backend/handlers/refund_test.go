@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCancelOrderRestoresStock(t *testing.T) {
+	setupTestDB(t)
+	useStubProvider(t, &stubProvider{})
+	user := createTestUser(t, "correct horse")
+	product := createTestProduct(t, 100000, 10)
+	order := createTestOrder(t, user, product, 3, models.OrderStatusProcessing)
+	createPaidPayment(t, order)
+
+	r := gin.New()
+	r.POST("/orders/:id/cancel", asUser(user), CancelOrder)
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/orders/%d/cancel", order.ID), gin.H{"reason": "Changed my mind"}); w.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", w.Code, w.Body)
+	}
+
+	if stock := productStock(t, product.ID); stock != 10 {
+		t.Fatalf("stock is %d after cancelling, want 10", stock)
+	}
+	db.First(order, order.ID)
+	if order.Status != models.OrderStatusCancelled || order.RefundedAmount != 300000 {
+		t.Fatalf("order is %s with %.0f refunded, want CANCELLED with 300000", order.Status, order.RefundedAmount)
+	}
+
+	// Cancelling again changes nothing
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/orders/%d/cancel", order.ID), gin.H{}); w.Code != http.StatusConflict {
+		t.Fatalf("second cancel: %d %s, want 409", w.Code, w.Body)
+	}
+	if stock := productStock(t, product.ID); stock != 10 {
+		t.Fatalf("stock is %d after cancelling twice, want 10", stock)
+	}
+}
+
+func TestRefundRestocksOnlyOnSuccess(t *testing.T) {
+	setupTestDB(t)
+	provider := &stubProvider{refundErr: errors.New("gateway is down")}
+	useStubProvider(t, provider)
+	user := createTestUser(t, "correct horse")
+	admin := createTestUser(t, "correct horse")
+	t.Cleanup(func() {
+		db.Where("actor_id = ?", admin.ID).Delete(&models.AuditLog{})
+	})
+	product := createTestProduct(t, 100000, 10)
+	order := createTestOrder(t, user, product, 3, models.OrderStatusDelivered)
+	p := createPaidPayment(t, order)
+	var item models.OrderItem
+	db.Where("order_id = ?", order.ID).First(&item)
+
+	r := gin.New()
+	r.POST("/orders/:id/refunds", asUser(admin), CreateRefund)
+	r.POST("/refunds/:id/retry", asUser(admin), RetryRefund)
+	r.POST("/refunds/:id/cancel", asUser(admin), CancelRefund)
+	refundOne := gin.H{"items": []gin.H{{"order_item_id": item.ID, "quantity": 1, "restock": true}}}
+	latestRefund := func() *models.Refund {
+		var refund models.Refund
+		db.Where("order_id = ?", order.ID).Order("id DESC").First(&refund)
+		return &refund
+	}
+	check := func(stock, refunded, restocked int) {
+		t.Helper()
+		if got := productStock(t, product.ID); got != stock {
+			t.Errorf("stock is %d, want %d", got, stock)
+		}
+		db.First(&item, item.ID)
+		if item.RefundedQuantity != refunded || item.RestockedQuantity != restocked {
+			t.Errorf("item has %d refunded and %d restocked, want %d and %d", item.RefundedQuantity, item.RestockedQuantity, refunded, restocked)
+		}
+	}
+
+	// A refund the gateway turns down restocks nothing, but holds on to the
+	// item until it is retried or cancelled
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/orders/%d/refunds", order.ID), refundOne); w.Code != http.StatusBadGateway {
+		t.Fatalf("refund with the gateway down: %d %s, want 502", w.Code, w.Body)
+	}
+	failed := latestRefund()
+	if failed.Status != models.RefundStatusFailed {
+		t.Fatalf("refund is %s, want FAILED", failed.Status)
+	}
+	check(7, 1, 0)
+
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/refunds/%d/cancel", failed.ID), nil); w.Code != http.StatusOK {
+		t.Fatalf("cancel refund: %d %s", w.Code, w.Body)
+	}
+	check(7, 0, 0)
+
+	// Once the gateway is back, the unit goes back into stock with the refund
+	provider.refundErr = nil
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/orders/%d/refunds", order.ID), refundOne); w.Code != http.StatusCreated {
+		t.Fatalf("refund: %d %s", w.Code, w.Body)
+	}
+	check(8, 1, 1)
+
+	// A failed refund that is retried restocks when the retry succeeds
+	provider.refundErr = errors.New("gateway is down")
+	serveJSON(r, http.MethodPost, fmt.Sprintf("/orders/%d/refunds", order.ID), refundOne)
+	check(8, 2, 1)
+	provider.refundErr = nil
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/refunds/%d/retry", latestRefund().ID), nil); w.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", w.Code, w.Body)
+	}
+	check(9, 2, 2)
+
+	// A refund stuck in PENDING, e.g. because its outcome could not be
+	// recorded, can be retried once it has been pending for a while
+	refundItems, amount, err := refundOrderItems(db, order, []refundLine{{OrderItemID: item.ID, Quantity: 1, Restock: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck, err := createRefund(db, order, p, amount, "Stuck", &admin.ID, refundItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/refunds/%d/retry", stuck.ID), nil); w.Code != http.StatusConflict {
+		t.Fatalf("retry of a fresh pending refund: %d %s, want 409", w.Code, w.Body)
+	}
+	db.Model(stuck).UpdateColumn("updated_at", time.Now().Add(-refundPendingTimeout-time.Minute))
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/refunds/%d/retry", stuck.ID), nil); w.Code != http.StatusOK {
+		t.Fatalf("retry of a stuck refund: %d %s", w.Code, w.Body)
+	}
+	check(10, 3, 3)
+
+	db.First(p, p.ID)
+	if p.RefundedAmount != 300000 || p.Status != models.PaymentStatusRefunded {
+		t.Fatalf("payment has %.0f refunded and is %s, want 300000 and REFUNDED", p.RefundedAmount, p.Status)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-backend/imaging"
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const renditionSweepInterval = time.Minute
+
+var renditionQueue = make(chan uint, 256)
+
+// StartRenditionWorkers starts n goroutines generating the renditions of
+// uploaded images. Uploads are queued as they arrive; a periodic sweep picks
+// up anything left pending, such as uploads made while the queue was full or
+// interrupted by a restart.
+func StartRenditionWorkers(n int) {
+	db.Model(&models.Media{}).Where("rendition_status = ?", models.RenditionsProcessing).
+		Update("rendition_status", models.RenditionsPending)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for mediaID := range renditionQueue {
+				processRenditions(mediaID)
+			}
+		}()
+	}
+	go func() {
+		for {
+			sweepPendingRenditions()
+			time.Sleep(renditionSweepInterval)
+		}
+	}()
+}
+
+// enqueueRenditions never blocks; when the queue is full the sweep gets to
+// the media later.
+func enqueueRenditions(mediaID uint) {
+	select {
+	case renditionQueue <- mediaID:
+	default:
+	}
+}
+
+func sweepPendingRenditions() {
+	var ids []uint
+	db.Model(&models.Media{}).Where("rendition_status = ?", models.RenditionsPending).
+		Order("id").Limit(cap(renditionQueue)).Pluck("id", &ids)
+	for _, id := range ids {
+		enqueueRenditions(id)
+	}
+}
+
+// processRenditions claims a pending media and renders every preset in every
+// format. A media is only claimed once, so duplicate queue entries are
+// harmless.
+func processRenditions(mediaID uint) {
+	claim := db.Model(&models.Media{}).
+		Where("id = ? AND rendition_status = ?", mediaID, models.RenditionsPending).
+		Update("rendition_status", models.RenditionsProcessing)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+	// A panic in a decoder must not take down the worker, or the server
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Panic while generating renditions for media", mediaID, "-", r)
+			db.Model(&models.Media{}).Where("id = ?", mediaID).Updates(map[string]interface{}{
+				"rendition_status": models.RenditionsFailed,
+				"rendition_error":  fmt.Sprint("panic: ", r),
+			})
+		}
+	}()
+
+	var media models.Media
+	if err := db.Preload("Renditions").First(&media, mediaID).Error; err != nil {
+		return
+	}
+	renditions, err := renderMedia(context.Background(), &media)
+	if err != nil {
+		log.Println("Failed to generate renditions for media", mediaID, "-", err)
+		db.Model(&media).Updates(map[string]interface{}{
+			"rendition_status": models.RenditionsFailed,
+			"rendition_error":  err.Error(),
+		})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("media_id = ?", media.ID).Delete(&models.MediaRendition{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&renditions).Error; err != nil {
+			return err
+		}
+		return tx.Model(&media).Updates(map[string]interface{}{
+			"rendition_status": models.RenditionsReady,
+			"rendition_error":  "",
+		}).Error
+	})
+	if err != nil {
+		log.Println("Failed to save renditions for media", mediaID, "-", err)
+		db.Model(&media).Update("rendition_status", models.RenditionsPending)
+		return
+	}
+
+	// Files of earlier renditions that were not overwritten
+	current := make(map[string]bool, len(renditions))
+	for _, r := range renditions {
+		current[r.Key] = true
+	}
+	for _, old := range media.Renditions {
+		if !current[old.Key] {
+			store.Delete(context.Background(), old.Key)
+		}
+	}
+}
+
+// renderMedia stores the renditions of media and returns their records.
+// Uncropped presets that would come out at the same size as a smaller one
+// are skipped, since images are never upscaled.
+func renderMedia(ctx context.Context, media *models.Media) ([]models.MediaRendition, error) {
+	// Uploads made before the pixel limit existed are not decoded either
+	if tooManyPixels(media.Width, media.Height) {
+		return nil, fmt.Errorf("image is larger than %d megapixels", maxImagePixels/1_000_000)
+	}
+	original, err := store.Get(ctx, media.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+	src, err := imaging.Decode(original)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	base := strings.TrimSuffix(media.Key, path.Ext(media.Key))
+	renderedWidths := map[int]bool{}
+	var renditions []models.MediaRendition
+	for _, preset := range imaging.Presets {
+		width, height := imaging.Size(src.Bounds(), preset)
+		if !preset.Crop {
+			if renderedWidths[width] {
+				continue
+			}
+			renderedWidths[width] = true
+		}
+		resized := imaging.Resize(src, preset)
+
+		for _, format := range imaging.Formats {
+			var buf bytes.Buffer
+			if err := imaging.Encode(&buf, resized, format); err != nil {
+				return nil, fmt.Errorf("encode %s %s: %w", preset.Name, format, err)
+			}
+			key := base + "_" + preset.Name + imaging.Extensions[format]
+			size := int64(buf.Len())
+			if err := store.Put(ctx, key, &buf, imaging.ContentTypes[format]); err != nil {
+				return nil, err
+			}
+			renditions = append(renditions, models.MediaRendition{
+				MediaID: media.ID,
+				Preset:  preset.Name,
+				Format:  format,
+				Key:     key,
+				URL:     store.URL(key),
+				Width:   width,
+				Height:  height,
+				Size:    size,
+				Cropped: preset.Crop,
+			})
+		}
+	}
+	return renditions, nil
+}
+
+// RegenerateRenditions queues a media for rendition generation again, e.g.
+// after a failure or when the presets have changed.
+func RegenerateRenditions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		return
+	}
+	result := db.Model(&models.Media{}).
+		Where("id = ? AND rendition_status <> ?", id, models.RenditionsProcessing).
+		Updates(map[string]interface{}{"rendition_status": models.RenditionsPending, "rendition_error": ""})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue renditions"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		db.Model(&models.Media{}).Where("id = ?", id).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "Renditions are already being generated"})
+		}
+		return
+	}
+	enqueueRenditions(uint(id))
+	recordAudit(c, models.AuditRegenerate, "media", uint(id), nil, nil)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Renditions queued"})
+}
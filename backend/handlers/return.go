@@ -0,0 +1,560 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxReturnPhotos = 5
+
+// MaxReturnRequestSize is the largest return form accepted: the photos and
+// room for the other fields.
+const MaxReturnRequestSize = maxReturnPhotos*maxUploadSize + 1<<20
+
+// returnError explains why a return cannot be opened or moved on.
+type returnError struct {
+	message string
+}
+
+func (e *returnError) Error() string {
+	return e.message
+}
+
+var errInvalidReturnTransition = errors.New("invalid return status transition")
+
+// openReturnStatuses are the statuses of returns whose items are still on
+// their way back or waiting for a refund.
+var openReturnStatuses = []string{models.ReturnStatusRequested, models.ReturnStatusApproved, models.ReturnStatusReceived}
+
+func preloadReturn(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Items.OrderItem.Product", withDeleted).Preload("Photos").
+		Preload("Events", orderHistoryOrder).Preload("Refund.Items")
+}
+
+// transitionReturn moves a locked return to status and records the step.
+func transitionReturn(tx *gorm.DB, ret *models.ReturnRequest, status string, actorID *uint, note string) error {
+	if !ret.CanTransitionTo(status) {
+		return errInvalidReturnTransition
+	}
+	from := ret.Status
+	ret.Status = status
+	if err := tx.Model(ret).Update("status", status).Error; err != nil {
+		return err
+	}
+	return recordReturnEvent(tx, ret.ID, from, status, actorID, note)
+}
+
+func recordReturnEvent(tx *gorm.DB, returnID uint, from, to string, actorID *uint, note string) error {
+	return tx.Create(&models.ReturnEvent{
+		ReturnRequestID: returnID,
+		FromStatus:      from,
+		ToStatus:        to,
+		ActorID:         actorID,
+		Note:            note,
+	}).Error
+}
+
+// storeReturnPhoto stores an image attached to a return. As with the media
+// library, the type is sniffed from the contents.
+func storeReturnPhoto(ctx context.Context, header *multipart.FileHeader) (*models.ReturnPhoto, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUploadSize {
+		return nil, &returnError{fmt.Sprintf("Photo %s is larger than %d MB", header.Filename, maxUploadSize>>20)}
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := uploadTypes[contentType]
+	if !ok {
+		return nil, &returnError{"Photos must be JPEG, PNG, GIF or WebP images"}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &returnError{fmt.Sprintf("Photo %s is not a valid image", header.Filename)}
+	}
+	if tooManyPixels(config.Width, config.Height) {
+		return nil, &returnError{fmt.Sprintf("Photo %s is larger than %d megapixels", header.Filename, maxImagePixels/1_000_000)}
+	}
+
+	key, err := uploadKey("returns", ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, key, bytes.NewReader(data), contentType); err != nil {
+		return nil, err
+	}
+	return &models.ReturnPhoto{
+		Key:         key,
+		URL:         store.URL(key),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       config.Width,
+		Height:      config.Height,
+	}, nil
+}
+
+func respondReturnError(c *gin.Context, err error, action string, ret *models.ReturnRequest, status string) {
+	var returnErr *returnError
+	var refundErr *refundError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+	case errors.Is(err, errInvalidReturnTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot move return from " + ret.Status + " to " + status})
+	case errors.As(err, &returnErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": returnErr.message})
+	case errors.As(err, &refundErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": refundErr.message})
+	case errors.Is(err, errNoRefundablePayment):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has no paid payment to refund"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " return"})
+	}
+}
+
+// --- Customer returns ---
+
+// CreateReturn opens a return against items of a delivered order. It takes
+// a multipart form with the reason, the items as a JSON list of
+// order_item_id and quantity, and up to five photos.
+func CreateReturn(c *gin.Context) {
+	userID := c.GetUint("userID")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxReturnRequestSize)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Photos are too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form"})
+		return
+	}
+
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	var lines []struct {
+		OrderItemID uint `json:"order_item_id"`
+		Quantity    int  `json:"quantity"`
+	}
+	if err := json.Unmarshal([]byte(c.PostForm("items")), &lines); err != nil || len(lines) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Items must be a JSON list of order_item_id and quantity"})
+		return
+	}
+	for _, line := range lines {
+		if line.Quantity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantities must be at least 1"})
+			return
+		}
+	}
+	files := form.File["photos"]
+	if len(files) > maxReturnPhotos {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d photos can be attached", maxReturnPhotos)})
+		return
+	}
+
+	var photos []models.ReturnPhoto
+	removePhotos := func() {
+		for _, photo := range photos {
+			store.Delete(context.Background(), photo.Key)
+		}
+	}
+	for _, header := range files {
+		photo, err := storeReturnPhoto(c.Request.Context(), header)
+		if err != nil {
+			removePhotos()
+			var returnErr *returnError
+			if errors.As(err, &returnErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": returnErr.message})
+				return
+			}
+			log.Println("Failed to store return photo:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store photo"})
+			return
+		}
+		photos = append(photos, *photo)
+	}
+
+	ret := models.ReturnRequest{UserID: userID, Status: models.ReturnStatusRequested, Reason: reason, Photos: photos}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusDelivered {
+			return &returnError{"Only delivered orders can be returned"}
+		}
+		var items []models.OrderItem
+		if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+			return err
+		}
+		itemsByID := make(map[uint]models.OrderItem, len(items))
+		for _, item := range items {
+			itemsByID[item.ID] = item
+		}
+		var open []struct {
+			OrderItemID uint
+			Quantity    int
+		}
+		if err := tx.Model(&models.ReturnItem{}).
+			Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+			Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+			Where("return_requests.order_id = ? AND return_requests.status IN ?", order.ID, openReturnStatuses).
+			Group("return_items.order_item_id").Scan(&open).Error; err != nil {
+			return err
+		}
+		returning := make(map[uint]int, len(open))
+		for _, o := range open {
+			returning[o.OrderItemID] = o.Quantity
+		}
+
+		seen := map[uint]bool{}
+		for _, line := range lines {
+			item, ok := itemsByID[line.OrderItemID]
+			if !ok || seen[line.OrderItemID] {
+				return &returnError{fmt.Sprintf("Order item %d is not part of the order or is listed twice", line.OrderItemID)}
+			}
+			seen[line.OrderItemID] = true
+			if left := item.Quantity - item.RefundedQuantity - returning[item.ID]; line.Quantity > left {
+				return &returnError{fmt.Sprintf("Only %d of order item %d can still be returned", left, item.ID)}
+			}
+			ret.Items = append(ret.Items, models.ReturnItem{OrderItemID: item.ID, Quantity: line.Quantity})
+		}
+
+		ret.OrderID = order.ID
+		if err := tx.Create(&ret).Error; err != nil {
+			return err
+		}
+		return recordReturnEvent(tx, ret.ID, "", ret.Status, &userID, reason)
+	})
+	if err != nil {
+		removePhotos()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		} else {
+			respondReturnError(c, err, "open", &ret, "")
+		}
+		return
+	}
+
+	db.Scopes(preloadReturn).First(&ret, ret.ID)
+	c.JSON(http.StatusCreated, ret)
+}
+
+func returnKey(r *models.ReturnRequest) (interface{}, uint) {
+	return r.CreatedAt, r.ID
+}
+
+func GetMyReturns(c *gin.Context) {
+	userID := c.GetUint("userID")
+	query := db.Model(&models.ReturnRequest{}).Where("user_id = ?", userID)
+	respondPage(c, query, newestFirst("return_requests", returnKey), "returns", preload("Items.OrderItem.Product", withDeleted), preload("Photos"))
+}
+
+func GetMyReturn(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var ret models.ReturnRequest
+	if err := db.Scopes(preloadReturn).Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&ret).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// CancelReturn withdraws a return that staff have not decided on yet.
+func CancelReturn(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var ret models.ReturnRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&ret).Error; err != nil {
+			return err
+		}
+		return transitionReturn(tx, &ret, models.ReturnStatusCancelled, &userID, "Cancelled by the customer")
+	})
+	if err != nil {
+		respondReturnError(c, err, "cancel", &ret, models.ReturnStatusCancelled)
+		return
+	}
+	db.Scopes(preloadReturn).First(&ret, ret.ID)
+	c.JSON(http.StatusOK, ret)
+}
+
+// --- Admin returns ---
+
+func GetReturns(c *gin.Context) {
+	query := db.Model(&models.ReturnRequest{})
+	if status := c.Query("status"); status != "" {
+		if !models.IsValidReturnStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown return status"})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	respondPage(c, query, newestFirst("return_requests", returnKey), "returns", preload("Items.OrderItem.Product", withDeleted), preload("Photos"))
+}
+
+func GetReturn(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+	var ret models.ReturnRequest
+	if err := db.Scopes(preloadReturn).First(&ret, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// updateReturn runs step on a locked return and answers with the result.
+func updateReturn(c *gin.Context, action, status string, step func(tx *gorm.DB, ret *models.ReturnRequest, actorID *uint) error) (*models.ReturnRequest, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return nil, false
+	}
+	actorID := c.GetUint("userID")
+	var ret models.ReturnRequest
+	var before auditSnapshot
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ret, id).Error; err != nil {
+			return err
+		}
+		before = snapshot(ret)
+		return step(tx, &ret, &actorID)
+	})
+	if err != nil {
+		respondReturnError(c, err, action, &ret, status)
+		return nil, false
+	}
+	recordAudit(c, models.AuditUpdate, "return", ret.ID, before, snapshot(ret))
+	return &ret, true
+}
+
+func decideReturn(c *gin.Context, status string) {
+	var decideData struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&decideData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if status == models.ReturnStatusRejected && strings.TrimSpace(decideData.Note) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A note explaining the rejection is required"})
+		return
+	}
+	ret, ok := updateReturn(c, "update", status, func(tx *gorm.DB, ret *models.ReturnRequest, actorID *uint) error {
+		return transitionReturn(tx, ret, status, actorID, decideData.Note)
+	})
+	if !ok {
+		return
+	}
+	db.Scopes(preloadReturn).First(ret, ret.ID)
+	c.JSON(http.StatusOK, ret)
+}
+
+func ApproveReturn(c *gin.Context) { decideReturn(c, models.ReturnStatusApproved) }
+func RejectReturn(c *gin.Context)  { decideReturn(c, models.ReturnStatusRejected) }
+
+// ReceiveReturn records the goods of an approved return as they arrive:
+// how many came back, in what condition, and whether they go back into
+// stock or are written off.
+func ReceiveReturn(c *gin.Context) {
+	var receiveData struct {
+		Items []struct {
+			ReturnItemID     uint   `json:"return_item_id" binding:"required"`
+			ReceivedQuantity *int   `json:"received_quantity"`
+			Condition        string `json:"condition" binding:"required"`
+			Disposition      string `json:"disposition" binding:"required"`
+		} `json:"items" binding:"required,dive"`
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&receiveData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, input := range receiveData.Items {
+		switch input.Condition {
+		case models.ReturnConditionAsNew, models.ReturnConditionUsed, models.ReturnConditionDamaged:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Condition must be AS_NEW, USED or DAMAGED"})
+			return
+		}
+		if input.Disposition != models.ReturnDispositionRestock && input.Disposition != models.ReturnDispositionWriteOff {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Disposition must be RESTOCK or WRITE_OFF"})
+			return
+		}
+	}
+
+	ret, ok := updateReturn(c, "receive", models.ReturnStatusReceived, func(tx *gorm.DB, ret *models.ReturnRequest, actorID *uint) error {
+		if !ret.CanTransitionTo(models.ReturnStatusReceived) {
+			return errInvalidReturnTransition
+		}
+		// Lock the order against refunds made at the same time
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, ret.OrderID).Error; err != nil {
+			return err
+		}
+		var items []models.ReturnItem
+		if err := tx.Preload("OrderItem").Where("return_request_id = ?", ret.ID).Find(&items).Error; err != nil {
+			return err
+		}
+		if len(receiveData.Items) != len(items) {
+			return &returnError{"Record every item of the return"}
+		}
+		itemsByID := make(map[uint]*models.ReturnItem, len(items))
+		for i := range items {
+			itemsByID[items[i].ID] = &items[i]
+		}
+
+		for _, input := range receiveData.Items {
+			item, ok := itemsByID[input.ReturnItemID]
+			if !ok {
+				return &returnError{fmt.Sprintf("Item %d is not part of the return or is listed twice", input.ReturnItemID)}
+			}
+			delete(itemsByID, input.ReturnItemID)
+			received := item.Quantity
+			if input.ReceivedQuantity != nil {
+				received = *input.ReceivedQuantity
+			}
+			if received < 0 || received > item.Quantity {
+				return &returnError{fmt.Sprintf("Between 0 and %d of item %d can be received", item.Quantity, item.ID)}
+			}
+			if input.Disposition == models.ReturnDispositionRestock {
+				if received > item.OrderItem.Quantity-item.OrderItem.RestockedQuantity {
+					return &returnError{fmt.Sprintf("Order item %d is already back in stock", item.OrderItemID)}
+				}
+				if err := restockOrderItem(tx, item.OrderItem, received); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(item).Updates(map[string]interface{}{
+				"received_quantity": received,
+				"condition":         input.Condition,
+				"disposition":       input.Disposition,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		ret.ReceivedAt = &now
+		if err := tx.Model(ret).Update("received_at", now).Error; err != nil {
+			return err
+		}
+		return transitionReturn(tx, ret, models.ReturnStatusReceived, actorID, receiveData.Note)
+	})
+	if !ok {
+		return
+	}
+	db.Scopes(preloadReturn).First(ret, ret.ID)
+	c.JSON(http.StatusOK, ret)
+}
+
+// RefundReturn refunds the goods received for a return and closes it. The
+// amount, when given, replaces the received items' share of the order
+// total, e.g. to keep back a fee for damaged goods.
+func RefundReturn(c *gin.Context) {
+	var refundData struct {
+		Amount *float64 `json:"amount"`
+		Note   string   `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&refundData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if refundData.Amount != nil && roundMoney(*refundData.Amount) <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be positive"})
+		return
+	}
+
+	var refund *models.Refund
+	ret, ok := updateReturn(c, "refund", models.ReturnStatusRefunded, func(tx *gorm.DB, ret *models.ReturnRequest, actorID *uint) error {
+		if !ret.CanTransitionTo(models.ReturnStatusRefunded) {
+			return errInvalidReturnTransition
+		}
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, ret.OrderID).Error; err != nil {
+			return err
+		}
+		var items []models.ReturnItem
+		if err := tx.Where("return_request_id = ? AND received_quantity > 0", ret.ID).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 && refundData.Amount == nil {
+			return &returnError{"Nothing was received for this return; give an amount to refund"}
+		}
+		// Received goods were restocked or written off already
+		lines := make([]refundLine, len(items))
+		for i, item := range items {
+			lines[i] = refundLine{OrderItemID: item.OrderItemID, Quantity: item.ReceivedQuantity}
+		}
+
+		refundItems, amount, err := refundOrderItems(tx, &order, lines)
+		if err != nil {
+			return err
+		}
+		if refundData.Amount != nil {
+			amount = roundMoney(*refundData.Amount)
+		}
+		p, err := refundablePayment(tx, order.ID, nil, amount)
+		if err != nil {
+			return err
+		}
+		refund, err = createRefund(tx, &order, p, amount, fmt.Sprintf("Return %d", ret.ID), actorID, refundItems)
+		if err != nil {
+			return err
+		}
+		ret.RefundID = &refund.ID
+		if err := tx.Model(ret).Update("refund_id", refund.ID).Error; err != nil {
+			return err
+		}
+		return transitionReturn(tx, ret, models.ReturnStatusRefunded, actorID, refundData.Note)
+	})
+	if !ok {
+		return
+	}
+
+	err := processRefund(c, refund)
+	db.Scopes(preloadReturn).First(ret, ret.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The payment provider did not accept the refund", "return": ret})
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
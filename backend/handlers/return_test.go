@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestReturn opens a return of quantity units of an order item, without
+// photos.
+func requestReturn(r *gin.Engine, orderID, orderItemID uint, quantity int) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("reason", "Wrong size")
+	items, _ := json.Marshal([]gin.H{{"order_item_id": orderItemID, "quantity": quantity}})
+	form.WriteField("items", string(items))
+	form.Close()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/orders/%d/returns", orderID), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestReturnRestocksReceivedGoodsOnce(t *testing.T) {
+	setupTestDB(t)
+	useStubProvider(t, &stubProvider{})
+	user := createTestUser(t, "correct horse")
+	staff := createTestUser(t, "correct horse")
+	product := createTestProduct(t, 100000, 10)
+	order := createTestOrder(t, user, product, 3, models.OrderStatusDelivered)
+	createPaidPayment(t, order)
+	var item models.OrderItem
+	db.Where("order_id = ?", order.ID).First(&item)
+	t.Cleanup(func() {
+		returns := db.Model(&models.ReturnRequest{}).Select("id").Where("order_id = ?", order.ID)
+		db.Where("return_request_id IN (?)", returns).Delete(&models.ReturnEvent{})
+		db.Where("return_request_id IN (?)", returns).Delete(&models.ReturnPhoto{})
+		db.Where("return_request_id IN (?)", returns).Delete(&models.ReturnItem{})
+		db.Where("order_id = ?", order.ID).Delete(&models.ReturnRequest{})
+		db.Where("actor_id = ?", staff.ID).Delete(&models.AuditLog{})
+	})
+
+	r := gin.New()
+	r.POST("/orders/:id/returns", asUser(user), CreateReturn)
+	r.POST("/admin/returns/:id/approve", asUser(staff), ApproveReturn)
+	r.POST("/admin/returns/:id/receive", asUser(staff), ReceiveReturn)
+	r.POST("/admin/returns/:id/refund", asUser(staff), RefundReturn)
+
+	w := requestReturn(r, order.ID, item.ID, 2)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("request return: %d %s", w.Code, w.Body)
+	}
+	var ret models.ReturnRequest
+	json.Unmarshal(w.Body.Bytes(), &ret)
+	if len(ret.Items) != 1 {
+		t.Fatalf("return has %d items, want 1", len(ret.Items))
+	}
+
+	// Units already being returned can't be returned twice
+	if w := requestReturn(r, order.ID, item.ID, 2); w.Code != http.StatusBadRequest {
+		t.Fatalf("second return of the same units: %d %s, want 400", w.Code, w.Body)
+	}
+
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/admin/returns/%d/approve", ret.ID), gin.H{}); w.Code != http.StatusOK {
+		t.Fatalf("approve: %d %s", w.Code, w.Body)
+	}
+	if stock := productStock(t, product.ID); stock != 7 {
+		t.Fatalf("stock is %d before the goods arrived, want 7", stock)
+	}
+
+	receive := gin.H{"items": []gin.H{{
+		"return_item_id": ret.Items[0].ID,
+		"condition":      models.ReturnConditionAsNew,
+		"disposition":    models.ReturnDispositionRestock,
+	}}}
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/admin/returns/%d/receive", ret.ID), receive); w.Code != http.StatusOK {
+		t.Fatalf("receive: %d %s", w.Code, w.Body)
+	}
+	if stock := productStock(t, product.ID); stock != 9 {
+		t.Fatalf("stock is %d after receiving the goods, want 9", stock)
+	}
+
+	// The refund pays the goods back without restocking them again
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/admin/returns/%d/refund", ret.ID), gin.H{}); w.Code != http.StatusOK {
+		t.Fatalf("refund: %d %s", w.Code, w.Body)
+	}
+	if stock := productStock(t, product.ID); stock != 9 {
+		t.Fatalf("stock is %d after the refund, want 9", stock)
+	}
+	db.First(&item, item.ID)
+	if item.RefundedQuantity != 2 || item.RestockedQuantity != 2 {
+		t.Fatalf("item has %d refunded and %d restocked, want 2 and 2", item.RefundedQuantity, item.RestockedQuantity)
+	}
+	db.First(order, order.ID)
+	if order.RefundedAmount != 200000 {
+		t.Fatalf("order has %.0f refunded, want 200000", order.RefundedAmount)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func reviewKey(r *models.Review) (interface{}, uint) {
+	return r.CreatedAt, r.ID
+}
+
+func approvedReviews(tx *gorm.DB) *gorm.DB {
+	return tx.Where("status = ?", models.ReviewStatusApproved).Order("created_at DESC")
+}
+
+// refreshProductRating recomputes the cached rating and review count of a
+// product from its approved reviews.
+func refreshProductRating(tx *gorm.DB, productID uint) error {
+	return tx.Exec(`UPDATE products SET
+		rating = COALESCE((SELECT ROUND(AVG(rating)::numeric, 2) FROM reviews WHERE product_id = ? AND status = ?), 0),
+		review_count = (SELECT COUNT(*) FROM reviews WHERE product_id = ? AND status = ?)
+		WHERE id = ?`,
+		productID, models.ReviewStatusApproved, productID, models.ReviewStatusApproved, productID).Error
+}
+
+// hasReceivedProduct reports whether the user has a delivered order that
+// contains the product.
+func hasReceivedProduct(userID, productID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?", userID, models.OrderStatusDelivered, productID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// --- Public review routes ---
+func GetProductReviews(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	query := db.Model(&models.Review{}).Where("product_id = ? AND status = ?", id, models.ReviewStatusApproved)
+	respondPage(c, query, newestFirst("reviews", reviewKey), "reviews", preload("User"))
+}
+
+func CreateReview(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var reviewData struct {
+		Rating  int    `json:"rating" binding:"required"`
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&reviewData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if reviewData.Rating < 1 || reviewData.Rating > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 1 and 5"})
+		return
+	}
+
+	var product models.Product
+	if err := db.First(&product, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	received, err := hasReceivedProduct(userID, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check purchase history"})
+		return
+	}
+	if !received {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only customers who received this product can review it"})
+		return
+	}
+
+	var existing int64
+	db.Model(&models.Review{}).Where("product_id = ? AND user_id = ?", product.ID, userID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this product"})
+		return
+	}
+
+	review := models.Review{
+		ProductID: product.ID,
+		UserID:    userID,
+		Rating:    reviewData.Rating,
+		Comment:   strings.TrimSpace(reviewData.Comment),
+		Status:    models.ReviewStatusPending,
+	}
+	if err := db.Omit("User").Create(&review).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
+		return
+	}
+	c.JSON(http.StatusCreated, review)
+}
+
+// --- Review moderation ---
+func GetReviews(c *gin.Context) {
+	query := db.Model(&models.Review{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+
+	respondPage(c, query, newestFirst("reviews", reviewKey), "reviews", preload("User"))
+}
+
+func UpdateReviewStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return
+	}
+	var statusData struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&statusData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.IsValidReviewStatus(statusData.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown review status"})
+		return
+	}
+
+	var review models.Review
+	var before auditSnapshot
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&review, id).Error; err != nil {
+			return err
+		}
+		before = snapshot(review)
+		if err := tx.Model(&review).Update("status", statusData.Status).Error; err != nil {
+			return err
+		}
+		return refreshProductRating(tx, review.ProductID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
+		return
+	}
+	recordAudit(c, models.AuditUpdate, "review", review.ID, before, snapshot(review))
+	c.JSON(http.StatusOK, review)
+}
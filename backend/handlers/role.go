@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errUnknownPermission = errors.New("unknown permission")
+
+// --- Roles & Permissions ---
+func GetPermissions(c *gin.Context) {
+	var permissions []models.Permission
+	if err := db.Order("code").Find(&permissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch permissions"})
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+func GetRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func CreateRole(c *gin.Context) {
+	var roleData struct {
+		Name        string   `json:"name" binding:"required"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&roleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.Role{
+		Name:        strings.ToUpper(strings.TrimSpace(roleData.Name)),
+		Description: roleData.Description,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		permissions, err := findPermissions(tx, roleData.Permissions)
+		if err != nil {
+			return err
+		}
+		role.Permissions = permissions
+		return tx.Create(&role).Error
+	})
+	if errors.Is(err, errUnknownPermission) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+	recordAudit(c, models.AuditCreate, "role", role.ID, nil, snapshot(role))
+	c.JSON(http.StatusCreated, role)
+}
+
+func UpdateRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	var role models.Role
+	if err := db.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if role.Name == models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The ADMIN role always has every permission"})
+		return
+	}
+	before := snapshot(role)
+
+	var roleData struct {
+		Description *string  `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&roleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if roleData.Description != nil {
+			if err := tx.Model(&role).Update("description", *roleData.Description).Error; err != nil {
+				return err
+			}
+		}
+		if roleData.Permissions == nil {
+			return nil
+		}
+		permissions, err := findPermissions(tx, roleData.Permissions)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if errors.Is(err, errUnknownPermission) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	db.Preload("Permissions").First(&role, role.ID)
+	recordAudit(c, models.AuditUpdate, "role", role.ID, before, snapshot(role))
+	c.JSON(http.StatusOK, role)
+}
+
+func DeleteRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	var role models.Role
+	if err := db.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if models.IsBuiltinRole(role.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+	var userCount int64
+	db.Model(&models.User{}).Where("role = ?", role.Name).Count(&userCount)
+	if userCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is still assigned to users"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "role", role.ID, snapshot(role), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+func findPermissions(tx *gorm.DB, codes []string) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if len(codes) == 0 {
+		return permissions, nil
+	}
+	if err := tx.Where("code IN ?", codes).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	if len(permissions) != len(uniqueStrings(codes)) {
+		return nil, errUnknownPermission
+	}
+	return permissions, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// roleExists reports whether name is a role defined in the roles table.
+func roleExists(name string) bool {
+	var count int64
+	db.Model(&models.Role{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
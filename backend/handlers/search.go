@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/search"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSuggestLimit = 5
+	maxSuggestLimit     = 10
+	defaultReportLimit  = 20
+	maxReportLimit      = 100
+	defaultReportWindow = 30 * 24 * time.Hour
+)
+
+type productSuggestion struct {
+	ID       uint    `json:"id"`
+	Name     string  `json:"name"`
+	ImageURL string  `json:"image_url"`
+	Price    float64 `json:"price"`
+}
+
+type categorySuggestion struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type blogSuggestion struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+}
+
+// SearchSuggest returns typeahead suggestions for the q parameter. It is
+// meant to be called on every keystroke, so the queries are not recorded.
+func SearchSuggest(c *gin.Context) {
+	limit := defaultSuggestLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, maxSuggestLimit)
+	}
+	response := gin.H{
+		"products":   []productSuggestion{},
+		"categories": []categorySuggestion{},
+		"blogs":      []blogSuggestion{},
+	}
+
+	query := search.Parse(c.Query("q"))
+	if len([]rune(query.Folded)) < search.MinSuggestLength {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	products := []productSuggestion{}
+	if err := db.Model(&models.Product{}).Select("id", "name", "image_url", "price").
+		Where(query.Contains("products.search_name")).
+		Order(query.Closeness("products.search_name")).Limit(limit).
+		Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+	categories := []categorySuggestion{}
+	if err := db.Model(&models.Category{}).Select("id", "name", "slug").
+		Where(query.Contains("categories.search_name")).
+		Order(query.Closeness("categories.search_name")).Limit(limit).
+		Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+	blogs := []blogSuggestion{}
+	if err := db.Model(&models.Blog{}).Select("id", "title").
+		Where("published = ?", true).
+		Where(query.Contains("blogs.search_title")).
+		Order(query.Closeness("blogs.search_title")).Limit(limit).
+		Find(&blogs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+
+	response["products"] = products
+	response["categories"] = categories
+	response["blogs"] = blogs
+	c.JSON(http.StatusOK, response)
+}
+
+// recordSearch stores a storefront search in the background so analytics
+// never slow down the listing.
+func recordSearch(query search.Query, resultCount int64) {
+	entry := models.SearchQuery{
+		Query:       query.Raw,
+		Normalized:  query.Folded,
+		ResultCount: resultCount,
+	}
+	go func() {
+		if err := db.Create(&entry).Error; err != nil {
+			log.Println("Failed to record search query:", err)
+		}
+	}()
+}
+
+type searchQueryStat struct {
+	Query          string    `json:"query"`
+	Searches       int64     `json:"searches"`
+	AvgResults     float64   `json:"avg_results"`
+	LastSearchedAt time.Time `json:"last_searched_at"`
+}
+
+// --- Admin search analytics ---
+
+func GetSearchReport(c *gin.Context) {
+	to := time.Now()
+	from := to.Add(-defaultReportWindow)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected RFC3339"})
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected RFC3339"})
+			return
+		}
+		to = t
+	}
+	limit := defaultReportLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, maxReportLimit)
+	}
+
+	window := func() *gorm.DB {
+		return db.Model(&models.SearchQuery{}).Where("created_at BETWEEN ? AND ?", from, to)
+	}
+	stats := func(tx *gorm.DB, out *[]searchQueryStat) error {
+		return tx.Select("normalized AS query, COUNT(*) AS searches, AVG(result_count)::float8 AS avg_results, MAX(created_at) AS last_searched_at").
+			Group("normalized").Order("searches DESC, last_searched_at DESC").Limit(limit).
+			Scan(out).Error
+	}
+
+	var total, zeroResults int64
+	topQueries := []searchQueryStat{}
+	zeroResultQueries := []searchQueryStat{}
+	if err := window().Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build search report"})
+		return
+	}
+	if err := window().Where("result_count = 0").Count(&zeroResults).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build search report"})
+		return
+	}
+	if err := stats(window(), &topQueries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build search report"})
+		return
+	}
+	if err := stats(window().Where("result_count = 0"), &zeroResultQueries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build search report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":                 from,
+		"to":                   to,
+		"total_searches":       total,
+		"zero_result_searches": zeroResults,
+		"top_queries":          topQueries,
+		"zero_result_queries":  zeroResultQueries,
+	})
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// trashError explains why a trashed row cannot be restored or purged.
+type trashError struct {
+	message string
+}
+
+func (e *trashError) Error() string {
+	return e.message
+}
+
+// trash gives a soft-deleted model its admin trash: a listing of deleted
+// rows, restore and purge (permanent deletion). The hooks run inside the
+// restore or purge transaction, before the row itself is changed.
+type trash[T any] struct {
+	name  string
+	table string
+	// key returns the deletion time and ID of a row
+	key func(item *T) (interface{}, uint)
+	// beforeRestore may refuse the restore with a trashError
+	beforeRestore func(tx *gorm.DB, item *T) error
+	// beforePurge refuses the purge with a trashError or deletes the rows
+	// that depend on the purged one
+	beforePurge func(tx *gorm.DB, item *T) error
+}
+
+// withDeleted preloads rows even when they are in the trash.
+func withDeleted(tx *gorm.DB) *gorm.DB {
+	return tx.Unscoped()
+}
+
+func (t trash[T]) title() string {
+	return strings.ToUpper(t.name[:1]) + t.name[1:]
+}
+
+// trashedItem is a row in the trash listing. The models keep deleted_at out
+// of their JSON so it can't be set through the update endpoints; the listing
+// adds it next to the row's own fields.
+type trashedItem[T any] struct {
+	item      *T
+	deletedAt interface{}
+}
+
+func (t trashedItem[T]) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{}
+	data, err := json.Marshal(t.item)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["deleted_at"] = t.deletedAt
+	return json.Marshal(fields)
+}
+
+func (t trash[T]) list(c *gin.Context) {
+	query := db.Unscoped().Model(new(T)).Where(t.table + ".deleted_at IS NOT NULL")
+	key := sortKey[T]{Column: t.table + ".deleted_at", IDColumn: t.table + ".id", Desc: true, Key: t.key}
+	page, err := paginate(c, query, key)
+	if errors.Is(err, errInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted " + t.name + "s"})
+		return
+	}
+
+	items := make([]trashedItem[T], len(page.Items))
+	for i := range page.Items {
+		deletedAt, _ := t.key(&page.Items[i])
+		items[i] = trashedItem[T]{item: &page.Items[i], deletedAt: deletedAt}
+	}
+	c.JSON(http.StatusOK, Page[trashedItem[T]]{
+		Items:      items,
+		Total:      page.Total,
+		Page:       page.Page,
+		PageSize:   page.PageSize,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// find locks the trashed row with the ID in the path.
+func (t trash[T]) find(tx *gorm.DB, c *gin.Context) (*T, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	item := new(T)
+	err = tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).First(item).Error
+	return item, err
+}
+
+func (t trash[T]) respondError(c *gin.Context, action string, err error) {
+	var trashErr *trashError
+	var numErr *strconv.NumError
+	switch {
+	case errors.As(err, &numErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + t.name + " ID"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": t.title() + " not found in trash"})
+	case errors.As(err, &trashErr):
+		c.JSON(http.StatusConflict, gin.H{"error": trashErr.message})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " " + t.name})
+	}
+}
+
+func (t trash[T]) restore(c *gin.Context) {
+	var item *T
+	var before auditSnapshot
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if item, err = t.find(tx, c); err != nil {
+			return err
+		}
+		before = snapshot(item)
+		if t.beforeRestore != nil {
+			if err := t.beforeRestore(tx, item); err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Model(item).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		t.respondError(c, "restore", err)
+		return
+	}
+	db.First(item)
+	_, id := t.key(item)
+	recordAudit(c, models.AuditRestore, t.name, id, before, snapshot(item))
+	c.JSON(http.StatusOK, item)
+}
+
+func (t trash[T]) purge(c *gin.Context) {
+	var item *T
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if item, err = t.find(tx, c); err != nil {
+			return err
+		}
+		if t.beforePurge != nil {
+			if err := t.beforePurge(tx, item); err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(item).Error
+	})
+	if err != nil {
+		t.respondError(c, "purge", err)
+		return
+	}
+	_, id := t.key(item)
+	recordAudit(c, models.AuditPurge, t.name, id, snapshot(item), nil)
+	c.JSON(http.StatusOK, gin.H{"message": t.title() + " permanently deleted"})
+}
+
+// --- Products ---
+var productTrash = trash[models.Product]{
+	name:  "product",
+	table: "products",
+	key:   func(p *models.Product) (interface{}, uint) { return p.DeletedAt.Time, p.ID },
+	beforePurge: func(tx *gorm.DB, p *models.Product) error {
+		var orderCount int64
+		if err := tx.Model(&models.OrderItem{}).Where("product_id = ?", p.ID).
+			Distinct("order_id").Count(&orderCount).Error; err != nil {
+			return err
+		}
+		if orderCount > 0 {
+			return &trashError{fmt.Sprintf("Product is part of %d orders and cannot be purged", orderCount)}
+		}
+
+		variants := tx.Unscoped().Model(&models.ProductVariant{}).Select("id").Where("product_id = ?", p.ID)
+		options := tx.Model(&models.ProductOption{}).Select("id").Where("product_id = ?", p.ID)
+		for _, err := range []error{
+			tx.Exec("DELETE FROM variant_option_values WHERE product_variant_id IN (?)", variants).Error,
+			tx.Unscoped().Where("product_id = ?", p.ID).Delete(&models.ProductVariant{}).Error,
+			tx.Where("option_id IN (?)", options).Delete(&models.ProductOptionValue{}).Error,
+			tx.Where("product_id = ?", p.ID).Delete(&models.ProductOption{}).Error,
+			tx.Where("product_id = ?", p.ID).Delete(&models.ProductImage{}).Error,
+			tx.Where("product_id = ?", p.ID).Delete(&models.Review{}).Error,
+			tx.Where("product_id = ?", p.ID).Delete(&models.CartItem{}).Error,
+		} {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func GetTrashedProducts(c *gin.Context) { productTrash.list(c) }
+func RestoreProduct(c *gin.Context)     { productTrash.restore(c) }
+func PurgeProduct(c *gin.Context)       { productTrash.purge(c) }
+
+// --- Users ---
+var userTrash = trash[models.User]{
+	name:  "user",
+	table: "users",
+	key:   func(u *models.User) (interface{}, uint) { return u.DeletedAt.Time, u.ID },
+	beforeRestore: func(tx *gorm.DB, u *models.User) error {
+		if strings.HasSuffix(u.Email, "@"+anonymizedEmailDomain) {
+			return &trashError{"The account was closed by its owner and anonymised; it cannot be restored"}
+		}
+		return nil
+	},
+	beforePurge: func(tx *gorm.DB, u *models.User) error {
+		var orderCount int64
+		if err := tx.Model(&models.Order{}).Where("user_id = ?", u.ID).Count(&orderCount).Error; err != nil {
+			return err
+		}
+		if orderCount > 0 {
+			return &trashError{fmt.Sprintf("User has %d orders that must be kept and cannot be purged", orderCount)}
+		}
+
+		sessions := tx.Model(&models.Session{}).Select("id").Where("user_id = ?", u.ID)
+		carts := tx.Model(&models.Cart{}).Select("id").Where("user_id = ?", u.ID)
+		for _, err := range []error{
+			tx.Where("session_id IN (?)", sessions).Delete(&models.RefreshToken{}).Error,
+			tx.Where("user_id = ?", u.ID).Delete(&models.Session{}).Error,
+			tx.Where("user_id = ?", u.ID).Delete(&models.UserToken{}).Error,
+			tx.Unscoped().Where("user_id = ?", u.ID).Delete(&models.Address{}).Error,
+			tx.Where("cart_id IN (?)", carts).Delete(&models.CartItem{}).Error,
+			tx.Where("user_id = ?", u.ID).Delete(&models.Cart{}).Error,
+		} {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func GetTrashedUsers(c *gin.Context) { userTrash.list(c) }
+func RestoreUser(c *gin.Context)     { userTrash.restore(c) }
+func PurgeUser(c *gin.Context)       { userTrash.purge(c) }
+
+// --- Vouchers ---
+var voucherTrash = trash[models.Voucher]{
+	name:  "voucher",
+	table: "vouchers",
+	key:   func(v *models.Voucher) (interface{}, uint) { return v.DeletedAt.Time, v.ID },
+	beforePurge: func(tx *gorm.DB, v *models.Voucher) error {
+		var orderCount int64
+		if err := tx.Model(&models.Order{}).Where("voucher_id = ?", v.ID).Count(&orderCount).Error; err != nil {
+			return err
+		}
+		if orderCount > 0 {
+			return &trashError{fmt.Sprintf("Voucher was used by %d orders and cannot be purged", orderCount)}
+		}
+		return nil
+	},
+}
+
+func GetTrashedVouchers(c *gin.Context) { voucherTrash.list(c) }
+func RestoreVoucher(c *gin.Context)     { voucherTrash.restore(c) }
+func PurgeVoucher(c *gin.Context)       { voucherTrash.purge(c) }
+
+// --- Blogs ---
+var blogTrash = trash[models.Blog]{
+	name:  "blog",
+	table: "blogs",
+	key:   func(b *models.Blog) (interface{}, uint) { return b.DeletedAt.Time, b.ID },
+}
+
+func GetTrashedBlogs(c *gin.Context) { blogTrash.list(c) }
+func RestoreBlog(c *gin.Context)     { blogTrash.restore(c) }
+func PurgeBlog(c *gin.Context)       { blogTrash.purge(c) }
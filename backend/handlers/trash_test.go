@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestTrashedItemShowsDeletedAt(t *testing.T) {
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	product := models.Product{ID: 7, Name: "Ghế", DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}
+
+	// Outside the trash the deletion time is never part of a product
+	data, _ := json.Marshal(product)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if _, ok := fields["deleted_at"]; ok {
+		t.Fatalf("product JSON has deleted_at: %s", data)
+	}
+
+	data, err := json.Marshal(trashedItem[models.Product]{item: &product, deletedAt: product.DeletedAt.Time})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = nil
+	json.Unmarshal(data, &fields)
+	if fields["deleted_at"] != "2024-01-02T03:04:05Z" || fields["name"] != "Ghế" || fields["id"] != float64(7) {
+		t.Fatalf("unexpected trash entry: %s", data)
+	}
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidOptionValues = errors.New("variant must pick exactly one value of every option of the product")
+	errDuplicateVariant    = errors.New("another variant already has this combination of options")
+)
+
+func byPosition(tx *gorm.DB) *gorm.DB {
+	return tx.Order("position ASC, id ASC")
+}
+
+// preloadVariants loads the option matrix of a product: its options with
+// their values, and every variant with the option values it is made of.
+func preloadVariants(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Options", byPosition).
+		Preload("Options.Values", byPosition).
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Variants.OptionValues")
+}
+
+// syncProductStock sets a product's stock to the total of its variants.
+func syncProductStock(tx *gorm.DB, productID uint) error {
+	return tx.Exec(`UPDATE products SET stock = (
+		SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = ? AND deleted_at IS NULL
+	) WHERE id = ?`, productID, productID).Error
+}
+
+func productIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return 0, false
+	}
+	var count int64
+	db.Model(&models.Product{}).Where("id = ?", id).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// --- Product options ---
+func CreateProductOption(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var optionData struct {
+		Name     string   `json:"name" binding:"required"`
+		Position int      `json:"position"`
+		Values   []string `json:"values" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&optionData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	option := models.ProductOption{
+		ProductID: productID,
+		Name:      strings.TrimSpace(optionData.Name),
+		Position:  optionData.Position,
+	}
+	for i, value := range uniqueStrings(optionData.Values) {
+		if value = strings.TrimSpace(value); value != "" {
+			option.Values = append(option.Values, models.ProductOptionValue{Value: value, Position: i})
+		}
+	}
+	if len(option.Values) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An option needs at least one value"})
+		return
+	}
+
+	if err := db.Create(&option).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create option"})
+		return
+	}
+	recordAudit(c, models.AuditCreate, "product_option", option.ID, nil, snapshot(option))
+	c.JSON(http.StatusCreated, option)
+}
+
+func DeleteProductOption(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var option models.ProductOption
+	if err := db.Preload("Values").Where("id = ? AND product_id = ?", c.Param("optionId"), productID).First(&option).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Option not found"})
+		return
+	}
+
+	var used int64
+	db.Table("variant_option_values").
+		Joins("JOIN product_option_values ON product_option_values.id = variant_option_values.product_option_value_id").
+		Joins("JOIN product_variants ON product_variants.id = variant_option_values.product_variant_id AND product_variants.deleted_at IS NULL").
+		Where("product_option_values.option_id = ?", option.ID).
+		Count(&used)
+	if used > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option is used by variants, delete them first"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("option_id = ?", option.ID).Delete(&models.ProductOptionValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&option).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete option"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "product_option", option.ID, snapshot(option), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Option deleted"})
+}
+
+// --- Product variants ---
+
+type variantInput struct {
+	SKU            string   `json:"sku"`
+	Barcode        string   `json:"barcode"`
+	Price          *float64 `json:"price"`
+	Stock          int      `json:"stock"`
+	ImageURL       string   `json:"image_url"`
+	OptionValueIDs []uint   `json:"option_value_ids"`
+}
+
+// resolveOptionValues checks that ids pick exactly one value per option of
+// the product, and that no other variant uses the same combination.
+func resolveOptionValues(tx *gorm.DB, productID, variantID uint, ids []uint) ([]models.ProductOptionValue, error) {
+	var options []models.ProductOption
+	if err := tx.Preload("Values").Where("product_id = ?", productID).Find(&options).Error; err != nil {
+		return nil, err
+	}
+
+	picked := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		picked[id] = true
+	}
+	var values []models.ProductOptionValue
+	for _, option := range options {
+		matches := 0
+		for _, value := range option.Values {
+			if picked[value.ID] {
+				values = append(values, value)
+				matches++
+			}
+		}
+		if matches != 1 {
+			return nil, errInvalidOptionValues
+		}
+	}
+	if len(values) != len(picked) {
+		return nil, errInvalidOptionValues
+	}
+
+	var siblings []models.ProductVariant
+	if err := tx.Preload("OptionValues").Where("product_id = ? AND id <> ?", productID, variantID).Find(&siblings).Error; err != nil {
+		return nil, err
+	}
+	signature := optionSignature(values)
+	for _, sibling := range siblings {
+		if optionSignature(sibling.OptionValues) == signature {
+			return nil, errDuplicateVariant
+		}
+	}
+	return values, nil
+}
+
+func optionSignature(values []models.ProductOptionValue) string {
+	ids := make([]int, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, int(v.ID))
+	}
+	sort.Ints(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func variantError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, errInvalidOptionValues), errors.Is(err, errDuplicateVariant):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU is already in use"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " variant"})
+	}
+}
+
+func CreateVariant(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var input variantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.SKU = strings.TrimSpace(input.SKU)
+	if input.SKU == "" || input.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SKU is required and stock cannot be negative"})
+		return
+	}
+
+	variant := models.ProductVariant{
+		ProductID: productID,
+		SKU:       input.SKU,
+		Barcode:   input.Barcode,
+		Price:     input.Price,
+		Stock:     input.Stock,
+		ImageURL:  input.ImageURL,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		values, err := resolveOptionValues(tx, productID, 0, input.OptionValueIDs)
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("OptionValues").Create(&variant).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&variant).Association("OptionValues").Replace(values); err != nil {
+			return err
+		}
+		return syncProductStock(tx, productID)
+	})
+	if err != nil {
+		variantError(c, err, "create")
+		return
+	}
+
+	db.Preload("OptionValues").First(&variant, variant.ID)
+	recordAudit(c, models.AuditCreate, "product_variant", variant.ID, nil, snapshot(variant))
+	c.JSON(http.StatusCreated, variant)
+}
+
+func UpdateVariant(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var variant models.ProductVariant
+	if err := db.Preload("OptionValues").Where("id = ? AND product_id = ?", c.Param("variantId"), productID).First(&variant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+	before := snapshot(variant)
+
+	input := variantInput{
+		SKU:      variant.SKU,
+		Barcode:  variant.Barcode,
+		Price:    variant.Price,
+		Stock:    variant.Stock,
+		ImageURL: variant.ImageURL,
+	}
+	for _, value := range variant.OptionValues {
+		input.OptionValueIDs = append(input.OptionValueIDs, value.ID)
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.SKU = strings.TrimSpace(input.SKU)
+	if input.SKU == "" || input.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SKU is required and stock cannot be negative"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		values, err := resolveOptionValues(tx, productID, variant.ID, input.OptionValueIDs)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&variant).Select("sku", "barcode", "price", "stock", "image_url").Updates(models.ProductVariant{
+			SKU:      input.SKU,
+			Barcode:  input.Barcode,
+			Price:    input.Price,
+			Stock:    input.Stock,
+			ImageURL: input.ImageURL,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&variant).Association("OptionValues").Replace(values); err != nil {
+			return err
+		}
+		return syncProductStock(tx, productID)
+	})
+	if err != nil {
+		variantError(c, err, "update")
+		return
+	}
+
+	db.Preload("OptionValues").First(&variant, variant.ID)
+	recordAudit(c, models.AuditUpdate, "product_variant", variant.ID, before, snapshot(variant))
+	c.JSON(http.StatusOK, variant)
+}
+
+func DeleteVariant(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+	var variant models.ProductVariant
+	if err := db.Preload("OptionValues").Where("id = ? AND product_id = ?", c.Param("variantId"), productID).First(&variant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+	before := snapshot(variant)
+
+	// Variants are soft deleted because past order items still refer to them
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&variant).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, productID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+	recordAudit(c, models.AuditDelete, "product_variant", variant.ID, before, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted"})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	minPasswordLength    = 8
+)
+
+var mail mailer.Mailer
+
+func SetMailer(m mailer.Mailer) {
+	mail = m
+}
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// appURL is the storefront base URL used in links sent by email.
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
+
+// issueUserToken creates a new emailed token for the user and invalidates any
+// earlier unused token with the same purpose.
+func issueUserToken(tx *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	if err := tx.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error; err != nil {
+		return "", err
+	}
+
+	token, hash, err := utils.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Create(&models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: now.Add(ttl),
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken marks a valid token as used and returns it.
+func consumeUserToken(tx *gorm.DB, token, purpose string) (*models.UserToken, error) {
+	var userToken models.UserToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", utils.HashToken(token), purpose).
+		First(&userToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidUserToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if userToken.UsedAt != nil || now.After(userToken.ExpiresAt) {
+		return nil, errInvalidUserToken
+	}
+	if err := tx.Model(&userToken).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	return &userToken, nil
+}
+
+func sendVerificationEmail(user *models.User) error {
+	token, err := issueUserToken(db, user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThe link expires in %d hours.\n",
+			user.Name, appURL(), token, int(emailVerificationTTL.Hours())),
+	})
+}
+
+func ForgotPassword(c *gin.Context) {
+	var forgotData struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&forgotData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Always answer the same way so the endpoint can't be used to find out
+	// which emails have an account
+	response := gin.H{"message": "If an account exists for this email, a reset link has been sent"}
+
+	var user models.User
+	if err := db.Where("email = ?", forgotData.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, err := issueUserToken(db, user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+	if err := mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Open the link below to choose a new one:\n\n%s/reset-password?token=%s\n\nThe link expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+			user.Name, appURL(), token, int(passwordResetTTL.Minutes())),
+	}); err != nil {
+		log.Println("Failed to send password reset email:", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func ResetPassword(c *gin.Context) {
+	var resetData struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&resetData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(resetData.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(resetData.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, resetData.Token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		// Following the emailed link also proves ownership of the address
+		if err := tx.Model(&models.User{}).Where("id = ?", userToken.UserID).Updates(map[string]interface{}{
+			"password":          string(hashedPassword),
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, userToken.UserID, "password reset")
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func VerifyEmail(c *gin.Context) {
+	var verifyData struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&verifyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, verifyData.Token, models.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ? AND email_verified_at IS NULL", userToken.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+func ResendVerification(c *gin.Context) {
+	var resendData struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&resendData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.Where("email = ? AND email_verified_at IS NULL", resendData.Email).First(&user).Error; err == nil {
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Println("Failed to send verification email:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists and is unverified, a verification email has been sent"})
+}
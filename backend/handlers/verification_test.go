@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var tokenInLink = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func postJSON(handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+// sentToken returns the token in the last email sent to the address.
+func sentToken(t *testing.T, outbox *mailer.MemoryMailer, to string) string {
+	t.Helper()
+	messages := outbox.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To == to {
+			match := tokenInLink.FindStringSubmatch(messages[i].Body)
+			if match == nil {
+				t.Fatalf("email to %s has no token link:\n%s", to, messages[i].Body)
+			}
+			return match[1]
+		}
+	}
+	t.Fatalf("no email was sent to %s", to)
+	return ""
+}
+
+func testEmail() string {
+	return fmt.Sprintf("%d@test.example.com", time.Now().UnixNano())
+}
+
+func createTestUser(t *testing.T, password string) *models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: testEmail(), Password: string(hash), Name: "Test", Role: models.RoleUser}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id = ?", user.ID).Delete(&models.UserToken{})
+		db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+		db.Unscoped().Delete(&user)
+	})
+	return &user
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	setupTestDB(t)
+	outbox := mailer.NewMemoryMailer()
+	SetMailer(outbox)
+
+	email := testEmail()
+	w := postJSON(Register, gin.H{
+		"email":             email,
+		"password":          "correct horse",
+		"name":              "Test",
+		"email_verified_at": "2020-01-01T00:00:00Z",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id = ?", user.ID).Delete(&models.UserToken{})
+		db.Unscoped().Delete(&user)
+	})
+	if user.EmailVerifiedAt != nil {
+		t.Fatal("register accepted email_verified_at from the client")
+	}
+
+	token := sentToken(t, outbox, email)
+	if w := postJSON(VerifyEmail, gin.H{"token": token}); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	db.First(&user, user.ID)
+	if user.EmailVerifiedAt == nil {
+		t.Fatal("email was not marked verified")
+	}
+	if w := postJSON(VerifyEmail, gin.H{"token": token}); w.Code != http.StatusBadRequest {
+		t.Fatalf("second use of the token: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRegisterRejectsShortPassword(t *testing.T) {
+	setupTestDB(t)
+	SetMailer(mailer.NewMemoryMailer())
+	if w := postJSON(Register, gin.H{"email": testEmail(), "password": "short"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("register with a short password: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	setupTestDB(t)
+	outbox := mailer.NewMemoryMailer()
+	SetMailer(outbox)
+	user := createTestUser(t, "old password")
+	session := models.Session{UserID: user.ID}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if w := postJSON(ForgotPassword, gin.H{"email": user.Email}); w.Code != http.StatusOK {
+		t.Fatalf("forgot password: %d %s", w.Code, w.Body)
+	}
+	token := sentToken(t, outbox, user.Email)
+
+	if w := postJSON(ResetPassword, gin.H{"token": token, "password": "new password"}); w.Code != http.StatusOK {
+		t.Fatalf("reset password: %d %s", w.Code, w.Body)
+	}
+	db.First(user, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new password")) != nil {
+		t.Fatal("password was not changed")
+	}
+	if user.EmailVerifiedAt == nil {
+		t.Fatal("resetting the password did not verify the email")
+	}
+	db.First(&session, session.ID)
+	if session.RevokedAt == nil {
+		t.Fatal("sessions were not revoked")
+	}
+	if w := postJSON(ResetPassword, gin.H{"token": token, "password": "another password"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("second use of the token: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestIssueUserTokenInvalidatesEarlierTokens(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "password")
+
+	first, err := issueUserToken(db, user.ID, models.TokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := issueUserToken(db, user.ID, models.TokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consumeUserToken(db, first, models.TokenPurposePasswordReset); err != errInvalidUserToken {
+		t.Fatalf("earlier token: got %v, want errInvalidUserToken", err)
+	}
+	// A token is only valid for its own purpose
+	if _, err := consumeUserToken(db, second, models.TokenPurposeEmailVerification); err != errInvalidUserToken {
+		t.Fatalf("token used for another purpose: got %v, want errInvalidUserToken", err)
+	}
+	if _, err := consumeUserToken(db, second, models.TokenPurposePasswordReset); err != nil {
+		t.Fatalf("latest token: %v", err)
+	}
+}
+
+func TestConsumeUserTokenRejectsExpiredTokens(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "password")
+
+	token, err := issueUserToken(db, user.ID, models.TokenPurposeEmailVerification, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consumeUserToken(db, token, models.TokenPurposeEmailVerification); err != errInvalidUserToken {
+		t.Fatalf("expired token: got %v, want errInvalidUserToken", err)
+	}
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"ecommerce-backend/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func CreateVoucher(c *gin.Context) {
@@ -15,10 +22,14 @@ func CreateVoucher(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := db.Create(&voucher).Error; err != nil {
+	if err := db.Create(&voucher).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Voucher code is already in use, possibly by a voucher in the trash"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create voucher"})
 		return
 	}
+	recordAudit(c, models.AuditCreate, "voucher", voucher.ID, nil, snapshot(voucher))
 	c.JSON(http.StatusCreated, voucher)
 }
 
@@ -33,6 +44,7 @@ func UpdateVoucher(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Voucher not found"})
 		return
 	}
+	before := snapshot(voucher)
 	if err := c.ShouldBindJSON(&voucher); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -41,6 +53,7 @@ func UpdateVoucher(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update voucher"})
 		return
 	}
+	recordAudit(c, models.AuditUpdate, "voucher", voucher.ID, before, snapshot(voucher))
 	c.JSON(http.StatusOK, voucher)
 }
 
@@ -50,9 +63,157 @@ func DeleteVoucher(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid voucher ID"})
 		return
 	}
-	if err := db.Unscoped().Delete(&models.Voucher{}, id).Error; err != nil {
+	var voucher models.Voucher
+	if err := db.First(&voucher, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voucher not found"})
+		return
+	}
+	before := snapshot(voucher)
+	if err := db.Delete(&voucher).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete voucher"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Voucher deleted"})
+	recordAudit(c, models.AuditDelete, "voucher", voucher.ID, before, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Voucher moved to trash"})
+}
+
+// voucherError is a voucher rule violation whose message is safe to show to
+// customers.
+type voucherError struct {
+	message string
+}
+
+func (e *voucherError) Error() string {
+	return e.message
+}
+
+var (
+	errVoucherNotFound  = &voucherError{"Voucher not found"}
+	errVoucherInactive  = &voucherError{"Voucher is not active"}
+	errVoucherExpired   = &voucherError{"Voucher has expired"}
+	errVoucherExhausted = &voucherError{"Voucher usage limit reached"}
+	errVoucherInvalid   = &voucherError{"Voucher is misconfigured"}
+)
+
+func normalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// findVoucher looks a voucher up by its case-insensitive code.
+func findVoucher(tx *gorm.DB, code string) (*models.Voucher, error) {
+	var voucher models.Voucher
+	if err := tx.Where("UPPER(code) = ?", normalizeVoucherCode(code)).First(&voucher).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errVoucherNotFound
+		}
+		return nil, err
+	}
+	return &voucher, nil
+}
+
+// voucherDiscount checks every rule on the voucher against an order subtotal
+// and returns the amount to take off it.
+func voucherDiscount(voucher *models.Voucher, subtotal float64, now time.Time) (float64, error) {
+	if !voucher.IsActive {
+		return 0, errVoucherInactive
+	}
+	if voucher.ExpiresAt != nil && now.After(*voucher.ExpiresAt) {
+		return 0, errVoucherExpired
+	}
+	if voucher.UsageLimit > 0 && voucher.UsedCount >= voucher.UsageLimit {
+		return 0, errVoucherExhausted
+	}
+	if subtotal < voucher.MinOrderValue {
+		return 0, &voucherError{fmt.Sprintf("Order subtotal must be at least %.0f to use this voucher", voucher.MinOrderValue)}
+	}
+
+	var discount float64
+	switch strings.ToLower(voucher.DiscountType) {
+	case "percentage":
+		if voucher.DiscountValue <= 0 || voucher.DiscountValue > 100 {
+			return 0, errVoucherInvalid
+		}
+		discount = subtotal * voucher.DiscountValue / 100
+		if voucher.MaxDiscount > 0 && discount > voucher.MaxDiscount {
+			discount = voucher.MaxDiscount
+		}
+	case "fixed":
+		if voucher.DiscountValue <= 0 {
+			return 0, errVoucherInvalid
+		}
+		discount = voucher.DiscountValue
+	default:
+		return 0, errVoucherInvalid
+	}
+
+	if discount > subtotal {
+		discount = subtotal
+	}
+	return math.Round(discount*100) / 100, nil
+}
+
+// redeemVoucher locks the voucher, validates it against subtotal and counts
+// one use. It must run inside the order transaction.
+func redeemVoucher(tx *gorm.DB, code string, subtotal float64) (*models.Voucher, float64, error) {
+	voucher, err := findVoucher(tx.Clauses(clause.Locking{Strength: "UPDATE"}), code)
+	if err != nil {
+		return nil, 0, err
+	}
+	discount, err := voucherDiscount(voucher, subtotal, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	result := tx.Model(&models.Voucher{}).
+		Where("id = ? AND (usage_limit = 0 OR used_count < usage_limit)", voucher.ID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, 0, errVoucherExhausted
+	}
+	return voucher, discount, nil
+}
+
+// --- Public voucher routes ---
+
+func ValidateVoucher(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var voucherData struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&voucherData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subtotal, err := cartSubtotal(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	voucher, err := findVoucher(db, voucherData.Code)
+	var discount float64
+	if err == nil {
+		discount, err = voucherDiscount(voucher, subtotal, time.Now())
+	}
+	var vErr *voucherError
+	if errors.As(err, &vErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": vErr.Error(), "valid": false})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate voucher"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":         true,
+		"code":          voucher.Code,
+		"description":   voucher.Description,
+		"discount_type": voucher.DiscountType,
+		"subtotal":      subtotal,
+		"discount":      discount,
+		"total":         subtotal - discount,
+	})
 }
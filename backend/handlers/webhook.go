@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errWebhookNotReplayable = errors.New("only failed or rejected events can be replayed")
+
+// receiveWebhook stores a callback from a payment gateway as it arrived and
+// then processes it.
+func receiveWebhook(c *gin.Context, provider payment.PaymentProvider, source string, body []byte) (*models.WebhookEvent, error) {
+	event := models.WebhookEvent{
+		Provider: provider.Name(),
+		Source:   source,
+		Method:   c.Request.Method,
+		Query:    c.Request.URL.RawQuery,
+		Body:     string(body),
+		RemoteIP: c.ClientIP(),
+		Status:   models.WebhookStatusReceived,
+	}
+	if err := db.Create(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, processWebhook(provider, &event)
+}
+
+// processWebhook verifies a stored event and applies it to its payment and
+// order. An event whose result was already processed, e.g. a gateway retry,
+// is marked as a duplicate and changes nothing.
+func processWebhook(provider payment.PaymentProvider, event *models.WebhookEvent) error {
+	event.Attempts++
+	query, _ := url.ParseQuery(event.Query)
+	n, err := provider.ParseNotification(query, []byte(event.Body))
+	if err != nil {
+		event.Status = models.WebhookStatusRejected
+		event.Error = err.Error()
+		db.Model(event).Select("status", "error", "attempts").Updates(event)
+		return err
+	}
+	event.EventID = n.EventID
+
+	refund, err := applyWebhook(event, n)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// A concurrent delivery of the same result got processed first
+		refund, err = applyWebhook(event, n)
+	}
+	if err != nil {
+		event.Status = models.WebhookStatusFailed
+		event.Error = err.Error()
+		db.Model(event).Select("status", "error", "attempts", "event_id", "payment_id").Updates(event)
+		return err
+	}
+	if refund != nil {
+		// The gateway is not kept waiting for its acknowledgement; a refund
+		// that fails is left for an admin to retry
+		log.Println("Refunding payment", refund.PaymentID, "of order", refund.OrderID, "-", refund.Reason)
+		go sendRefund(context.Background(), refund, "", event.RemoteIP)
+	}
+	return nil
+}
+
+func applyWebhook(event *models.WebhookEvent, n *payment.Notification) (*models.Refund, error) {
+	var refund *models.Refund
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var processed models.WebhookEvent
+		err := tx.Where("provider = ? AND event_id = ? AND status = ? AND id <> ?",
+			event.Provider, event.EventID, models.WebhookStatusProcessed, event.ID).First(&processed).Error
+		if err == nil {
+			event.Status = models.WebhookStatusDuplicate
+			event.DuplicateOf = &processed.ID
+			event.PaymentID = processed.PaymentID
+			event.Error = ""
+			return tx.Save(event).Error
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		p, r, err := applyPaymentNotification(tx, event.Provider, n)
+		if p != nil && p.ID != 0 {
+			event.PaymentID = &p.ID
+		}
+		if err != nil {
+			return err
+		}
+		refund = r
+		now := time.Now()
+		event.Status = models.WebhookStatusProcessed
+		event.ProcessedAt = &now
+		event.Error = ""
+		return tx.Save(event).Error
+	})
+	return refund, err
+}
+
+// --- Admin webhook inspection ---
+func GetWebhookEvents(c *gin.Context) {
+	query := db.Model(&models.WebhookEvent{})
+	if provider := c.Query("provider"); provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+	if paymentID := c.Query("payment_id"); paymentID != "" {
+		query = query.Where("payment_id = ?", paymentID)
+	}
+	respondPage(c, query, newestFirst("webhook_events", func(e *models.WebhookEvent) (interface{}, uint) { return e.CreatedAt, e.ID }), "webhook events")
+}
+
+func GetWebhookEvent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	var event models.WebhookEvent
+	if err := db.First(&event, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// ReplayWebhookEvent processes a failed or rejected event again, e.g. after
+// a misconfigured secret or a missing payment has been fixed.
+func ReplayWebhookEvent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	var event models.WebhookEvent
+	if err := db.First(&event, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+		return
+	}
+	if event.Status != models.WebhookStatusFailed && event.Status != models.WebhookStatusRejected {
+		c.JSON(http.StatusConflict, gin.H{"error": errWebhookNotReplayable.Error()})
+		return
+	}
+	provider, ok := paymentProviders[event.Provider]
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment provider " + event.Provider + " is not enabled"})
+		return
+	}
+
+	before := snapshot(event)
+	processWebhook(provider, &event)
+	recordAudit(c, models.AuditReplay, "webhook_event", event.ID, before, snapshot(event))
+	c.JSON(http.StatusOK, event)
+}
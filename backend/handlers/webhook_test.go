@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrentWebhookDeliveriesAreProcessedOnce(t *testing.T) {
+	setupTestDB(t)
+	useStubProvider(t, &stubProvider{})
+	user := createTestUser(t, "correct horse")
+	product := createTestProduct(t, 250000, 5)
+	order := createTestOrder(t, user, product, 2, models.OrderStatusPending)
+	p, err := newPayment(db, order, paymentProviders[stubProviderName])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventID := fmt.Sprintf("evt-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Where("provider = ? AND event_id = ?", stubProviderName, eventID).Delete(&models.WebhookEvent{})
+	})
+	body, _ := json.Marshal(payment.Notification{
+		EventID:       eventID,
+		Reference:     p.Reference,
+		TransactionID: "T" + eventID,
+		Amount:        p.Amount,
+		Status:        payment.StatusPaid,
+	})
+
+	// The gateway delivers the same result several times at once
+	r := gin.New()
+	r.POST("/payments/:provider/ipn", PaymentNotify)
+	const deliveries = 8
+	var wg sync.WaitGroup
+	codes := make([]int, deliveries)
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/"+stubProviderName+"/ipn", bytes.NewReader(body)))
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("delivery %d was answered with %d", i, code)
+		}
+	}
+
+	var events []models.WebhookEvent
+	db.Where("provider = ? AND event_id = ?", stubProviderName, eventID).Find(&events)
+	statuses := map[string]int{}
+	for _, event := range events {
+		statuses[event.Status]++
+	}
+	if len(events) != deliveries || statuses[models.WebhookStatusProcessed] != 1 || statuses[models.WebhookStatusDuplicate] != deliveries-1 {
+		t.Fatalf("events by status: %v, want 1 processed and %d duplicates", statuses, deliveries-1)
+	}
+
+	db.First(p, p.ID)
+	if p.Status != models.PaymentStatusPaid {
+		t.Fatalf("payment is %s, want PAID", p.Status)
+	}
+	var history []models.OrderStatusHistory
+	db.Where("order_id = ?", order.ID).Find(&history)
+	if len(history) != 1 || history[0].ToStatus != models.OrderStatusProcessing {
+		t.Fatalf("order history: %+v, want a single move to PROCESSING", history)
+	}
+	var refunds int64
+	db.Model(&models.Refund{}).Where("order_id = ?", order.ID).Count(&refunds)
+	if refunds != 0 {
+		t.Fatalf("%d refunds were made for a single payment", refunds)
+	}
+}
@@ -0,0 +1,106 @@
+// Package imaging renders the resized copies (renditions) of uploaded images
+// served to the storefront.
+package imaging
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	FormatWebP = "webp"
+	FormatJPEG = "jpeg"
+
+	jpegQuality = 82
+)
+
+// Formats lists the formats every preset is rendered in, preferred first.
+var Formats = []string{FormatWebP, FormatJPEG}
+
+var ContentTypes = map[string]string{
+	FormatWebP: "image/webp",
+	FormatJPEG: "image/jpeg",
+}
+
+var Extensions = map[string]string{
+	FormatWebP: ".webp",
+	FormatJPEG: ".jpg",
+}
+
+// Preset is a rendition size. Cropped presets are cut to exactly Width x
+// Height around the centre; the others keep the aspect ratio and are only
+// bounded by Width, which makes them usable in a srcset.
+type Preset struct {
+	Name   string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+var Presets = []Preset{
+	{Name: "thumb", Width: 200, Height: 200, Crop: true}, // cart, admin lists
+	{Name: "card", Width: 480, Height: 360, Crop: true},  // catalog grid, blog cards
+	{Name: "sm", Width: 480},
+	{Name: "md", Width: 960}, // product page
+	{Name: "lg", Width: 1600},
+}
+
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Size returns the dimensions of src rendered with p. Width-bound presets
+// never upscale.
+func Size(src image.Rectangle, p Preset) (int, int) {
+	if p.Crop {
+		return p.Width, p.Height
+	}
+	w, h := src.Dx(), src.Dy()
+	if w <= p.Width || w == 0 {
+		return w, h
+	}
+	return p.Width, max(1, h*p.Width/w)
+}
+
+// Resize renders src with p.
+func Resize(src image.Image, p Preset) image.Image {
+	bounds := src.Bounds()
+	w, h := Size(bounds, p)
+	from := bounds
+	if p.Crop {
+		// Largest centred region of src with the aspect ratio of the preset
+		if bounds.Dx()*h > bounds.Dy()*w {
+			cw := bounds.Dy() * w / h
+			x := bounds.Min.X + (bounds.Dx()-cw)/2
+			from = image.Rect(x, bounds.Min.Y, x+cw, bounds.Max.Y)
+		} else {
+			ch := bounds.Dx() * h / w
+			y := bounds.Min.Y + (bounds.Dy()-ch)/2
+			from = image.Rect(bounds.Min.X, y, bounds.Max.X, y+ch)
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, from, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in format. JPEG has no transparency, so transparent
+// areas are flattened onto white.
+func Encode(w io.Writer, img image.Image, format string) error {
+	if format == FormatWebP {
+		return nativewebp.Encode(w, img, nil)
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: jpegQuality})
+}
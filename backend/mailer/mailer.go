@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password resets.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv builds a mailer from the environment. MAIL_DRIVER selects "smtp",
+// "file" or "memory"; when it is unset SMTP is used if SMTP_HOST is set and
+// the file outbox otherwise.
+func FromEnv() Mailer {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		if os.Getenv("SMTP_HOST") != "" {
+			driver = "smtp"
+		} else {
+			driver = "file"
+		}
+	}
+
+	switch driver {
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getEnv("MAIL_FROM", "no-reply@ergolife.com"),
+		}
+	case "memory":
+		return NewMemoryMailer()
+	default:
+		dir := getEnv("MAIL_OUTBOX_DIR", "outbox")
+		log.Printf("Emails will be written to %s instead of being sent", dir)
+		return &FileMailer{Dir: dir, From: getEnv("MAIL_FROM", "no-reply@ergolife.com")}
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
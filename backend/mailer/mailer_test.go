@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailerKeepsMessages(t *testing.T) {
+	m := NewMemoryMailer()
+	if err := m.Send(Message{To: "a@example.com", Subject: "One", Body: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(Message{To: "b@example.com", Subject: "Two", Body: "second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := m.Messages()
+	if len(messages) != 2 || messages[0].To != "a@example.com" || messages[1].Subject != "Two" {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+	// Messages returns a copy
+	messages[0].To = "changed"
+	if m.Messages()[0].To != "a@example.com" {
+		t.Fatal("Messages exposed the mailer's own slice")
+	}
+
+	m.Reset()
+	if len(m.Messages()) != 0 {
+		t.Fatal("Reset kept messages")
+	}
+}
+
+func TestFileMailerWritesEml(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m := &FileMailer{Dir: dir, From: "shop@example.com"}
+	if err := m.Send(Message{To: "a@example.com", Subject: "Đặt lại mật khẩu", Body: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	eml := string(data)
+	for _, want := range []string{"From: shop@example.com\r\n", "To: a@example.com\r\n", "Subject: =?utf-8?q?", "\r\n\r\nHello"} {
+		if !strings.Contains(eml, want) {
+			t.Errorf("message is missing %q:\n%s", want, eml)
+		}
+	}
+}
+
+func TestFromEnvMemoryDriver(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "memory")
+	if _, ok := FromEnv().(*MemoryMailer); !ok {
+		t.Fatal("MAIL_DRIVER=memory did not give a MemoryMailer")
+	}
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"ecommerce-backend/handlers"
+	"ecommerce-backend/mailer"
 	"ecommerce-backend/middleware"
 	"ecommerce-backend/models"
+	"ecommerce-backend/payment"
 	"ecommerce-backend/routes"
+	"ecommerce-backend/search"
+	"ecommerce-backend/storage"
+	"ecommerce-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -34,7 +42,7 @@ func createDefaultAdmin(db *gorm.DB) {
 			Email:    "admin@ergolife.com",
 			Password: string(hashedPassword),
 			Name:     "Admin User",
-			Role:     "ADMIN",
+			Role:     models.RoleAdmin,
 		}
 
 		if err := db.Create(&admin).Error; err != nil {
@@ -46,6 +54,85 @@ func createDefaultAdmin(db *gorm.DB) {
 	}
 }
 
+// migrateProductCategories moves products from the old free-text category
+// column onto the category table, creating a root category for every
+// distinct name, and then drops the old column.
+func migrateProductCategories(db *gorm.DB) {
+	if !db.Migrator().HasColumn("products", "category") {
+		return
+	}
+
+	var names []string
+	if err := db.Raw("SELECT DISTINCT category FROM products WHERE category IS NOT NULL AND category <> ''").Scan(&names).Error; err != nil {
+		log.Println("Failed to read product categories:", err)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range names {
+			category := models.Category{Name: name, Slug: utils.Slugify(name)}
+			if err := tx.Where("slug = ?", category.Slug).FirstOrCreate(&category).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE products SET category_id = ? WHERE category = ?", category.ID, name).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("ALTER TABLE products DROP COLUMN category").Error
+	})
+	if err != nil {
+		log.Println("Failed to migrate product categories:", err)
+		return
+	}
+	log.Printf("Migrated %d product categories", len(names))
+}
+
+// seedRoles makes sure every known permission and built-in role exists.
+// Permissions introduced since the last start are granted to the built-in
+// roles that have them by default; existing grants edited by admins are left
+// alone.
+func seedRoles(db *gorm.DB) {
+	newPermissions := map[string]models.Permission{}
+	for _, p := range models.Permissions {
+		permission := p
+		result := db.Where("code = ?", permission.Code).FirstOrCreate(&permission)
+		if result.Error != nil {
+			log.Println("Failed to seed permission", permission.Code+":", result.Error)
+			continue
+		}
+		if result.RowsAffected > 0 {
+			newPermissions[permission.Code] = permission
+		}
+	}
+
+	for _, name := range []string{models.RoleAdmin, models.RoleStaff, models.RoleUser} {
+		role := models.Role{Name: name}
+		result := db.Where("name = ?", name).FirstOrCreate(&role)
+		if result.Error != nil {
+			log.Println("Failed to seed role", name+":", result.Error)
+			continue
+		}
+		createdRole := result.RowsAffected > 0
+
+		var grants []models.Permission
+		for _, code := range models.DefaultRolePermissions[name] {
+			if permission, ok := newPermissions[code]; ok {
+				grants = append(grants, permission)
+			} else if createdRole {
+				var permission models.Permission
+				if err := db.Where("code = ?", code).First(&permission).Error; err == nil {
+					grants = append(grants, permission)
+				}
+			}
+		}
+		if len(grants) > 0 {
+			if err := db.Model(&role).Association("Permissions").Append(grants); err != nil {
+				log.Println("Failed to grant permissions to role", name+":", err)
+			}
+		}
+	}
+}
+
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
@@ -58,28 +145,53 @@ func main() {
 		dsn = "host=localhost user=postgres password=newpassword dbname=ecommerce port=5432 sslmode=disable"
 	}
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
 	// Auto migrate the schema
-	db.AutoMigrate(&models.User{}, &models.Product{}, &models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{}, &models.Voucher{}, &models.Blog{})
+	db.AutoMigrate(&models.User{}, &models.Category{}, &models.Product{}, &models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{}, &models.OrderStatusHistory{}, &models.Voucher{}, &models.Blog{}, &models.Session{}, &models.RefreshToken{}, &models.UserToken{}, &models.Permission{}, &models.Role{}, &models.Address{}, &models.Review{}, &models.ProductOption{}, &models.ProductOptionValue{}, &models.ProductVariant{}, &models.SearchQuery{}, &models.Media{}, &models.MediaRendition{}, &models.ProductImage{}, &models.ImportJob{}, &models.AuditLog{}, &models.Payment{}, &models.WebhookEvent{}, &models.IdempotencyKey{}, &models.Refund{}, &models.RefundItem{}, &models.ReturnRequest{}, &models.ReturnItem{}, &models.ReturnPhoto{}, &models.ReturnEvent{})
+
+	// Orders used to be created with a lowercase "pending" status
+	db.Model(&models.Order{}).Where("status <> UPPER(status)").Update("status", gorm.Expr("UPPER(status)"))
+	// Import jobs used lowercase statuses and row actions as well
+	db.Model(&models.ImportJob{}).Where("status <> UPPER(status)").Update("status", gorm.Expr("UPPER(status)"))
+	db.Exec(`UPDATE import_jobs SET report = (
+		SELECT jsonb_agg(jsonb_set(r, '{action}', to_jsonb(UPPER(r->>'action'))) ORDER BY n)
+		FROM jsonb_array_elements(report) WITH ORDINALITY AS entries(r, n)
+	) WHERE jsonb_typeof(report) = 'array' AND report::text ~ '"action": *"[a-z]'`)
+
+	migrateProductCategories(db)
+	if err := search.Migrate(db); err != nil {
+		log.Println("Failed to set up search:", err)
+	}
+	seedRoles(db)
 
 	// Create default admin user if it doesn't exist
 	createDefaultAdmin(db)
 
 	handlers.SetDB(db)
+	handlers.SetMailer(mailer.FromEnv())
+	store := storage.FromEnv()
+	handlers.SetStorage(store)
+	handlers.StartRenditionWorkers(2)
+	handlers.FailInterruptedImports()
+	paymentProviders := payment.FromEnv()
+	handlers.SetPaymentProviders(paymentProviders)
 	middleware.SetDB(db)
+	middleware.StartIdempotencyKeyCleanup()
 
 	// Setup Gin router
 	r := gin.Default()
+	r.Use(middleware.RequestID())
 
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-Request-ID, Idempotency-Key")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -87,6 +199,21 @@ func main() {
 		c.Next()
 	})
 
+	// Serve uploads when they are kept on local disk
+	if local, ok := store.(*storage.LocalStorage); ok {
+		if base, err := url.Parse(local.BaseURL); err == nil && base.Host == "" {
+			r.Static(base.Path, local.Dir)
+		}
+	}
+
+	// Serve the stand-in payment gateway when fake payments are enabled
+	if fake, ok := paymentProviders[payment.ProviderFake].(*payment.Fake); ok {
+		if base, err := url.Parse(fake.GatewayURL); err == nil {
+			prefix := strings.TrimSuffix(base.Path, "/")
+			r.Any(prefix+"/*path", gin.WrapH(http.StripPrefix(prefix, payment.NewFakeGateway(fake.Secret))))
+		}
+	}
+
 	// Routes
 	routes.SetupRoutes(r)
 
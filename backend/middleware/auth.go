@@ -27,19 +27,30 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		userID, err := utils.ValidateJWT(tokenString)
+		userID, sessionID, err := utils.ValidateJWT(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		// Reject tokens of sessions that were logged out or revoked
+		var session models.Session
+		if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission only lets through users whose role grants every one of
+// perms. It must run after AuthMiddleware.
+func RequirePermission(perms ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -56,10 +67,23 @@ func AdminMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if user.Role != "ADMIN" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
+		if user.Role != models.RoleAdmin {
+			var granted int64
+			err := db.Table("role_permissions").
+				Joins("JOIN roles ON roles.id = role_permissions.role_id").
+				Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+				Where("roles.name = ? AND permissions.code IN ?", user.Role, perms).
+				Count(&granted).Error
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				c.Abort()
+				return
+			}
+			if int(granted) < len(perms) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				c.Abort()
+				return
+			}
 		}
 
 		c.Set("user", user)
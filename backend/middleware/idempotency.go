@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeyTTL        = 24 * time.Hour
+	idempotencyLockTimeout   = 5 * time.Minute
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentRequestBody = 32 << 20
+	idempotencyCleanupPeriod = time.Hour
+)
+
+var errIdempotencyKeyContended = errors.New("idempotency key is contended")
+
+// Idempotent makes a POST endpoint safe to retry. A request sent with an
+// Idempotency-Key header is carried out once; retries with the same key get
+// the original response replayed, and reusing the key for a different
+// request is rejected with 422. Requests without the header are unaffected.
+// It must run after AuthMiddleware, as keys are scoped to the user.
+func Idempotent() gin.HandlerFunc {
+	return IdempotentUpTo(maxIdempotentRequestBody)
+}
+
+// IdempotentUpTo is Idempotent for endpoints that accept bodies larger than
+// the default limit, such as uploads. The body is held in memory to
+// fingerprint it, so maxBody should be the largest the handler accepts.
+func IdempotentUpTo(maxBody int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		if int64(len(body)) > maxBody {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := models.IdempotencyKey{
+			UserID:      c.GetUint("userID"),
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			Fingerprint: requestFingerprint(c.Request, body),
+			Status:      models.IdempotencyStatusProcessing,
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		}
+		existing, err := claimIdempotencyKey(&record)
+		if err != nil {
+			log.Println("Failed to claim idempotency key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Method != record.Method || existing.Path != record.Path || existing.Fingerprint != record.Fingerprint:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case existing.Status != models.IdempotencyStatusCompleted:
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			default:
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(existing.ResponseStatus, existing.ContentType, existing.ResponseBody)
+			}
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not remembered so that the request can be retried
+		if recorder.Status() >= http.StatusInternalServerError {
+			db.Delete(&record)
+			return
+		}
+		if err := db.Model(&record).Updates(map[string]interface{}{
+			"status":          models.IdempotencyStatusCompleted,
+			"response_status": recorder.Status(),
+			"content_type":    recorder.Header().Get("Content-Type"),
+			"response_body":   recorder.body.Bytes(),
+		}).Error; err != nil {
+			log.Println("Failed to save idempotent response:", err)
+		}
+	}
+}
+
+// claimIdempotencyKey stores record, or returns the record already holding
+// its key. Expired records, and those of requests that died while being
+// processed, are replaced.
+func claimIdempotencyKey(record *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var existing models.IdempotencyKey
+		err := db.Where("user_id = ? AND key = ?", record.UserID, record.Key).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		abandoned := existing.Status == models.IdempotencyStatusProcessing && existing.UpdatedAt.Before(now.Add(-idempotencyLockTimeout))
+		if existing.ExpiresAt.After(now) && !abandoned {
+			return &existing, nil
+		}
+		if err := db.Where("id = ? AND updated_at = ?", existing.ID, existing.UpdatedAt).
+			Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return nil, err
+		}
+		record.ID = 0
+	}
+	return nil, errIdempotencyKeyContended
+}
+
+// requestFingerprint identifies the content of a request. JSON bodies are
+// compared after normalisation and multipart bodies part by part, since
+// clients pick a new random boundary every time they encode a form.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && hashMultipart(h, body, params["boundary"]):
+	case json.Valid(body):
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		decoder.Decode(&value)
+		normalized, _ := json.Marshal(value)
+		h.Write(normalized)
+	default:
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashMultipart(h hash.Hash, body []byte, boundary string) bool {
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return true
+		} else if err != nil {
+			return false
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return false
+		}
+		fmt.Fprintf(h, "%q %q %d\n", part.FormName(), part.FileName(), len(data))
+		h.Write(data)
+	}
+}
+
+// StartIdempotencyKeyCleanup periodically deletes expired idempotency keys.
+func StartIdempotencyKeyCleanup() {
+	go func() {
+		for {
+			db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+			time.Sleep(idempotencyCleanupPeriod)
+		}
+	}()
+}
+
+// responseRecorder keeps a copy of the response body as it is written.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
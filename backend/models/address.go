@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var vietnamesePhonePattern = regexp.MustCompile(`^(\+84|0)\d{9,10}$`)
+
+// ShippingAddress is a Vietnamese postal address: street line, ward
+// (phường/xã), district (quận/huyện) and province (tỉnh/thành phố).
+type ShippingAddress struct {
+	RecipientName string `json:"recipient_name"`
+	Phone         string `json:"phone"`
+	Street        string `json:"street"`
+	Ward          string `json:"ward"`
+	District      string `json:"district"`
+	Province      string `json:"province"`
+}
+
+// Normalize trims whitespace and strips separators from the phone number.
+func (a *ShippingAddress) Normalize() {
+	a.RecipientName = strings.TrimSpace(a.RecipientName)
+	a.Street = strings.TrimSpace(a.Street)
+	a.Ward = strings.TrimSpace(a.Ward)
+	a.District = strings.TrimSpace(a.District)
+	a.Province = strings.TrimSpace(a.Province)
+	a.Phone = strings.NewReplacer(" ", "", ".", "", "-", "").Replace(strings.TrimSpace(a.Phone))
+}
+
+func (a *ShippingAddress) Validate() error {
+	var missing []string
+	if a.RecipientName == "" {
+		missing = append(missing, "recipient_name")
+	}
+	if a.Phone == "" {
+		missing = append(missing, "phone")
+	}
+	if a.Street == "" {
+		missing = append(missing, "street")
+	}
+	if a.Ward == "" {
+		missing = append(missing, "ward")
+	}
+	if a.District == "" {
+		missing = append(missing, "district")
+	}
+	if a.Province == "" {
+		missing = append(missing, "province")
+	}
+	if len(missing) > 0 {
+		return errors.New("missing address fields: " + strings.Join(missing, ", "))
+	}
+	if !vietnamesePhonePattern.MatchString(a.Phone) {
+		return errors.New("invalid phone number")
+	}
+	return nil
+}
+
+type Address struct {
+	ID              uint `json:"id" gorm:"primaryKey"`
+	UserID          uint `json:"user_id" gorm:"index"`
+	ShippingAddress `gorm:"embedded"`
+	IsDefault       bool           `json:"is_default" gorm:"default:false"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	// AuditRegenerate queues the renditions of a media again
+	AuditRegenerate = "regenerate"
+	// AuditReplay reprocesses a stored webhook event
+	AuditReplay = "replay"
+	// AuditRetry sends a failed refund to the provider again
+	AuditRetry = "retry"
+	// AuditCancel gives up on a failed refund
+	AuditCancel = "cancel"
+)
+
+// AuditLog records one change made through the admin API. Changes holds the
+// fields that changed: creates only have after values and deletes only
+// before values.
+type AuditLog struct {
+	ID         uint                   `json:"id" gorm:"primaryKey"`
+	ActorID    *uint                  `json:"actor_id" gorm:"index"`
+	ActorEmail string                 `json:"actor_email"`
+	Action     string                 `json:"action" gorm:"not null;index"`
+	EntityType string                 `json:"entity_type" gorm:"not null;index:idx_audit_logs_entity"`
+	EntityID   uint                   `json:"entity_id" gorm:"index:idx_audit_logs_entity"`
+	Changes    map[string]AuditChange `json:"changes" gorm:"type:jsonb;serializer:json"`
+	IP         string                 `json:"ip"`
+	RequestID  string                 `json:"request_id" gorm:"index"`
+	CreatedAt  time.Time              `json:"created_at" gorm:"index"`
+}
+
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"time"
 
+	"ecommerce-backend/utils"
+
 	"gorm.io/gorm"
 )
 
@@ -13,8 +15,18 @@ type Blog struct {
 	Content   string         `json:"content" gorm:"type:text;column:content"`
 	Author    string         `json:"author" gorm:"not null;column:author"`
 	ImageURL  string         `json:"image_url" gorm:"column:image_url"`
+	ImageID   *uint          `json:"image_id"`
+	Image     *Media         `json:"image,omitempty" gorm:"foreignKey:ImageID"`
 	Published bool           `json:"published" gorm:"default:false;column:published"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Accent-folded copy of the title used by search suggestions
+	SearchTitle string `json:"-"`
+}
+
+func (b *Blog) BeforeSave(tx *gorm.DB) error {
+	b.SearchTitle = utils.FoldAccents(b.Title)
+	return nil
 }
@@ -14,9 +14,11 @@ type Cart struct {
 }
 
 type CartItem struct {
-	ID        uint    `json:"id" gorm:"primaryKey"`
-	CartID    uint    `json:"cart_id"`
-	ProductID uint    `json:"product_id"`
-	Product   Product `json:"product" gorm:"foreignKey:ProductID"`
-	Quantity  int     `json:"quantity"`
-}
\ No newline at end of file
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	CartID    uint            `json:"cart_id"`
+	ProductID uint            `json:"product_id"`
+	Product   Product         `json:"product" gorm:"foreignKey:ProductID"`
+	VariantID *uint           `json:"variant_id" gorm:"index"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+	Quantity  int             `json:"quantity"`
+}
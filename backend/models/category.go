@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"ecommerce-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Category is a node of the product category tree. Root categories have no
+// parent.
+type Category struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	ParentID    *uint      `json:"parent_id" gorm:"index"`
+	Children    []Category `json:"children,omitempty" gorm:"foreignKey:ParentID"`
+	Name        string     `json:"name" gorm:"not null"`
+	Slug        string     `json:"slug" gorm:"uniqueIndex;not null"`
+	Description string     `json:"description" gorm:"type:text"`
+	ImageURL    string     `json:"image_url"`
+	SortOrder   int        `json:"sort_order" gorm:"default:0"`
+	// ProductCount includes products in descendant categories
+	ProductCount int64     `json:"product_count" gorm:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Accent-folded copy of the name used by search suggestions
+	SearchName string `json:"-"`
+}
+
+func (c *Category) BeforeSave(tx *gorm.DB) error {
+	c.SearchName = utils.FoldAccents(c.Name)
+	return nil
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	IdempotencyStatusProcessing = "PROCESSING"
+	IdempotencyStatusCompleted  = "COMPLETED"
+)
+
+// IdempotencyKey remembers a request sent with an Idempotency-Key header and
+// the response it got, so that retries of the request get the same response
+// instead of being carried out again. Keys are scoped to the user.
+type IdempotencyKey struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string `gorm:"not null;size:255;uniqueIndex:idx_idempotency_keys_user_key"`
+	Method      string `gorm:"not null"`
+	Path        string `gorm:"not null"`
+	Fingerprint string `gorm:"not null"`
+	Status      string `gorm:"not null"`
+	// The response, once the request has completed
+	ResponseStatus int
+	ContentType    string
+	ResponseBody   []byte
+	ExpiresAt      time.Time `gorm:"not null;index"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
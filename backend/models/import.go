@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+const (
+	ImportStatusPending   = "PENDING"
+	ImportStatusRunning   = "RUNNING"
+	ImportStatusCompleted = "COMPLETED"
+	ImportStatusFailed    = "FAILED"
+)
+
+const (
+	ImportActionCreate    = "CREATE"
+	ImportActionUpdate    = "UPDATE"
+	ImportActionUnchanged = "UNCHANGED"
+	ImportActionError     = "ERROR"
+)
+
+// ImportJob is a bulk product import from a CSV or XLSX file. A dry run
+// validates every row and reports what would change without saving.
+type ImportJob struct {
+	ID            uint              `json:"id" gorm:"primaryKey"`
+	UserID        uint              `json:"user_id" gorm:"index"`
+	Filename      string            `json:"filename"`
+	Format        string            `json:"format"`
+	DryRun        bool              `json:"dry_run"`
+	Status        string            `json:"status" gorm:"default:'PENDING';index"`
+	TotalRows     int               `json:"total_rows"`
+	ProcessedRows int               `json:"processed_rows"`
+	Created       int               `json:"created"`
+	Updated       int               `json:"updated"`
+	Unchanged     int               `json:"unchanged"`
+	Failed        int               `json:"failed"`
+	Error         string            `json:"error,omitempty"`
+	Report        []ImportRowResult `json:"report,omitempty" gorm:"type:jsonb;serializer:json"`
+	StartedAt     *time.Time        `json:"started_at"`
+	FinishedAt    *time.Time        `json:"finished_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// ImportRowResult is the outcome of one spreadsheet row. Row is the line
+// number in the file, counting the header as line 1.
+type ImportRowResult struct {
+	Row    int      `json:"row"`
+	SKU    string   `json:"sku"`
+	Action string   `json:"action"`
+	Errors []string `json:"errors,omitempty"`
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RenditionsPending    = "pending"
+	RenditionsProcessing = "processing"
+	RenditionsReady      = "ready"
+	RenditionsFailed     = "failed"
+)
+
+// Media is a file uploaded to the media library. Key locates it in the
+// storage backend and URL is where it is served from.
+type Media struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	Key          string `json:"key" gorm:"uniqueIndex;not null"`
+	URL          string `json:"url" gorm:"not null"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type" gorm:"not null"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Checksum     string `json:"checksum"`
+	Alt          string `json:"alt"`
+	UploadedByID *uint  `json:"uploaded_by_id"`
+	// RenditionStatus tracks the background generation of Renditions
+	RenditionStatus string           `json:"rendition_status" gorm:"default:'pending';index"`
+	RenditionError  string           `json:"rendition_error,omitempty"`
+	Renditions      []MediaRendition `json:"renditions,omitempty" gorm:"foreignKey:MediaID"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+func (Media) TableName() string {
+	return "media"
+}
+
+// MediaRendition is a resized copy of a Media image in one format.
+type MediaRendition struct {
+	ID      uint   `json:"-" gorm:"primaryKey"`
+	MediaID uint   `json:"-" gorm:"index;not null"`
+	Preset  string `json:"preset" gorm:"not null"`
+	Format  string `json:"format" gorm:"not null"`
+	Key     string `json:"-" gorm:"not null"`
+	URL     string `json:"url" gorm:"not null"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Size    int64  `json:"size"`
+	// Cropped renditions have a fixed aspect ratio and are left out of Srcset
+	Cropped bool `json:"cropped"`
+}
+
+// Srcset returns, per format, a srcset attribute value built from the
+// uncropped renditions, e.g. {"webp": "a_sm.webp 480w, a_md.webp 960w"}.
+// It is empty until the renditions have been generated and preloaded.
+func (m *Media) Srcset() map[string]string {
+	byFormat := map[string][]MediaRendition{}
+	for _, r := range m.Renditions {
+		if !r.Cropped {
+			byFormat[r.Format] = append(byFormat[r.Format], r)
+		}
+	}
+	srcset := make(map[string]string, len(byFormat))
+	for format, renditions := range byFormat {
+		sort.Slice(renditions, func(i, j int) bool { return renditions[i].Width < renditions[j].Width })
+		candidates := make([]string, len(renditions))
+		for i, r := range renditions {
+			candidates[i] = r.URL + " " + strconv.Itoa(r.Width) + "w"
+		}
+		srcset[format] = strings.Join(candidates, ", ")
+	}
+	return srcset
+}
+
+func (m Media) MarshalJSON() ([]byte, error) {
+	type media Media
+	return json.Marshal(struct {
+		media
+		Srcset map[string]string `json:"srcset,omitempty"`
+	}{media(m), m.Srcset()})
+}
+
+// ProductImage places a media library image in a product's gallery. The
+// image at the lowest position is the product's cover.
+type ProductImage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProductID uint      `json:"product_id" gorm:"index;not null"`
+	MediaID   uint      `json:"media_id" gorm:"index;not null"`
+	Media     Media     `json:"media" gorm:"foreignKey:MediaID"`
+	Position  int       `json:"position" gorm:"default:0"`
+	CreatedAt time.Time `json:"created_at"`
+}
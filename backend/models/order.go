@@ -4,15 +4,77 @@ import (
 	"time"
 )
 
+const (
+	OrderStatusPending    = "PENDING"
+	OrderStatusProcessing = "PROCESSING"
+	OrderStatusShipped    = "SHIPPED"
+	OrderStatusDelivered  = "DELIVERED"
+	OrderStatusCancelled  = "CANCELLED"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// DELIVERED and CANCELLED are terminal.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusProcessing, OrderStatusCancelled},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:    {OrderStatusDelivered},
+}
+
 type Order struct {
-	ID          uint        `json:"id" gorm:"primaryKey"`
-	UserID      uint        `json:"user_id"`
-	User        User        `json:"user" gorm:"foreignKey:UserID"`
-	Items       []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
-	TotalAmount float64     `json:"total_amount"`
-	Status      string      `json:"status" gorm:"default:'pending'"`
-	CreatedAt   time.Time   `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
+	ID             uint                 `json:"id" gorm:"primaryKey"`
+	UserID         uint                 `json:"user_id"`
+	User           OrderCustomer        `json:"user" gorm:"foreignKey:UserID"`
+	Items          []OrderItem          `json:"items" gorm:"foreignKey:OrderID"`
+	History        []OrderStatusHistory `json:"history,omitempty" gorm:"foreignKey:OrderID"`
+	Payments       []Payment            `json:"payments,omitempty" gorm:"foreignKey:OrderID"`
+	Refunds        []Refund             `json:"refunds,omitempty" gorm:"foreignKey:OrderID"`
+	PaymentMethod  string               `json:"payment_method" gorm:"default:'cod'"`
+	Subtotal       float64              `json:"subtotal"`
+	DiscountAmount float64              `json:"discount_amount"`
+	VoucherID      *uint                `json:"voucher_id" gorm:"index"`
+	VoucherCode    string               `json:"voucher_code"`
+	TotalAmount    float64              `json:"total_amount"`
+	// RefundedAmount is the sum of the order's succeeded refunds
+	RefundedAmount float64 `json:"refunded_amount" gorm:"default:0"`
+	// VoucherReleased is set once the voucher use has been given back, when
+	// the order was cancelled or fully refunded
+	VoucherReleased bool `json:"voucher_released" gorm:"default:false"`
+	// ShippingAddress is copied from the address book at checkout so later
+	// edits don't change where past orders were shipped
+	ShippingAddress ShippingAddress `json:"shipping_address" gorm:"embedded;embeddedPrefix:shipping_"`
+	Status          string          `json:"status" gorm:"default:'PENDING';index"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// OrderCustomer is the view of the customer shown with an order.
+type OrderCustomer struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+func (OrderCustomer) TableName() string {
+	return "users"
+}
+
+// CanTransitionTo reports whether the order may legally move to status.
+func (o *Order) CanTransitionTo(status string) bool {
+	for _, next := range orderTransitions[o.Status] {
+		if next == status {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPending, OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled:
+		return true
+	}
+	return false
 }
 
 type OrderItem struct {
@@ -20,6 +82,26 @@ type OrderItem struct {
 	OrderID   uint    `json:"order_id"`
 	ProductID uint    `json:"product_id"`
 	Product   Product `json:"product" gorm:"foreignKey:ProductID"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-}
\ No newline at end of file
+	VariantID *uint   `json:"variant_id" gorm:"index"`
+	// SKU and VariantTitle are copied at checkout for the order history
+	SKU          string  `json:"sku"`
+	VariantTitle string  `json:"variant_title"`
+	Quantity     int     `json:"quantity"`
+	Price        float64 `json:"price"`
+	// RefundedQuantity and RestockedQuantity count the units refunded and
+	// put back into stock
+	RefundedQuantity  int `json:"refunded_quantity" gorm:"default:0"`
+	RestockedQuantity int `json:"restocked_quantity" gorm:"default:0"`
+}
+
+// OrderStatusHistory records every status change of an order. ActorID is nil
+// for changes made by the system.
+type OrderStatusHistory struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OrderID    uint      `json:"order_id" gorm:"index"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    *uint     `json:"actor_id"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
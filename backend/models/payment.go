@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	PaymentStatusPending   = "PENDING"
+	PaymentStatusPaid      = "PAID"
+	PaymentStatusFailed    = "FAILED"
+	PaymentStatusCancelled = "CANCELLED"
+	PaymentStatusRefunded  = "REFUNDED"
+)
+
+// Payment is one attempt at paying for an order through a payment provider.
+// Reference identifies the attempt at the gateway; an order may have several
+// attempts when earlier ones failed or were abandoned.
+type Payment struct {
+	ID        uint    `json:"id" gorm:"primaryKey"`
+	OrderID   uint    `json:"order_id" gorm:"not null;index"`
+	Provider  string  `json:"provider" gorm:"not null"`
+	Reference string  `json:"reference" gorm:"not null;uniqueIndex"`
+	Amount    float64 `json:"amount"`
+	// RefundedAmount is the sum of the payment's succeeded refunds; a
+	// payment refunded in full becomes REFUNDED
+	RefundedAmount float64    `json:"refunded_amount" gorm:"default:0"`
+	Status         string     `json:"status" gorm:"default:'PENDING';index"`
+	TransactionID  string     `json:"transaction_id"`
+	RedirectURL    string     `json:"redirect_url,omitempty"`
+	Message        string     `json:"message"`
+	PaidAt         *time.Time `json:"paid_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
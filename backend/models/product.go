@@ -1,20 +1,59 @@
 package models
 
 import (
+	"strings"
 	"time"
 
+	"ecommerce-backend/utils"
+
 	"gorm.io/gorm"
 )
 
 type Product struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"not null"`
-	Description string         `json:"description"`
-	Price       float64        `json:"price" gorm:"not null"`
-	ImageURL    string         `json:"image_url"`
-	Category    string         `json:"category"`
-	Stock       int            `json:"stock" gorm:"default:0"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
-}
\ No newline at end of file
+	ID          uint             `json:"id" gorm:"primaryKey"`
+	SKU         *string          `json:"sku" gorm:"uniqueIndex"`
+	Name        string           `json:"name" gorm:"not null"`
+	Description string           `json:"description"`
+	Price       float64          `json:"price" gorm:"not null"`
+	ImageURL    string           `json:"image_url"`
+	ImageID     *uint            `json:"image_id" gorm:"->"`
+	Image       *Media           `json:"image,omitempty" gorm:"foreignKey:ImageID"`
+	CategoryID  *uint            `json:"category_id" gorm:"index"`
+	Category    *Category        `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
+	Stock       int              `json:"stock" gorm:"default:0"`
+	Rating      float64          `json:"rating" gorm:"->;default:0"`
+	ReviewCount int              `json:"review_count" gorm:"->;default:0"`
+	Reviews     []Review         `json:"reviews,omitempty" gorm:"foreignKey:ProductID"`
+	Options     []ProductOption  `json:"options,omitempty" gorm:"foreignKey:ProductID"`
+	Variants    []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	Images      []ProductImage   `json:"images,omitempty" gorm:"foreignKey:ProductID"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt   `json:"-" gorm:"index"`
+
+	// Accent-folded copies of the name and description used by search
+	SearchName string `json:"-"`
+	SearchBody string `json:"-" gorm:"type:text"`
+	// SearchRank and Highlight are only filled in on search results
+	SearchRank float64          `json:"-" gorm:"->;-:migration"`
+	Highlight  *SearchHighlight `json:"highlight,omitempty" gorm:"-"`
+}
+
+// SearchHighlight holds HTML fragments with matched terms wrapped in <mark>.
+type SearchHighlight struct {
+	Name    string `json:"name"`
+	Snippet string `json:"snippet"`
+}
+
+func (p *Product) BeforeSave(tx *gorm.DB) error {
+	if p.SKU != nil {
+		if sku := strings.TrimSpace(*p.SKU); sku != "" {
+			p.SKU = &sku
+		} else {
+			p.SKU = nil
+		}
+	}
+	p.SearchName = utils.FoldAccents(p.Name)
+	p.SearchBody = utils.FoldAccents(p.Description)
+	return nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+const (
+	RefundStatusPending   = "PENDING"
+	RefundStatusSucceeded = "SUCCEEDED"
+	RefundStatusFailed    = "FAILED"
+	// RefundStatusCancelled is a failed refund given up on; its items can
+	// be refunded again
+	RefundStatusCancelled = "CANCELLED"
+)
+
+// Refund pays money of an order back through the provider of one of its
+// payments. Items lists what was returned or cancelled; a refund without
+// items only pays money back, e.g. when a whole order is cancelled or as a
+// goodwill gesture.
+type Refund struct {
+	ID            uint    `json:"id" gorm:"primaryKey"`
+	OrderID       uint    `json:"order_id" gorm:"not null;index"`
+	PaymentID     uint    `json:"payment_id" gorm:"not null;index"`
+	Provider      string  `json:"provider"`
+	Reference     string  `json:"reference" gorm:"not null;uniqueIndex"`
+	Amount        float64 `json:"amount"`
+	Reason        string  `json:"reason"`
+	Status        string  `json:"status" gorm:"default:'PENDING';index"`
+	TransactionID string  `json:"transaction_id"`
+	Message       string  `json:"message"`
+	ActorID       *uint   `json:"actor_id"`
+	// Overpayment refunds pay back a payment the order did not need, such
+	// as an abandoned attempt completed after the order was paid. They do
+	// not count towards the order's refunded amount.
+	Overpayment bool         `json:"overpayment"`
+	Items       []RefundItem `json:"items" gorm:"foreignKey:RefundID"`
+	RefundedAt  *time.Time   `json:"refunded_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// RefundItem is a quantity of an order item covered by a refund. Restock
+// records whether the units go back into stock, which happens once the
+// refund succeeds.
+type RefundItem struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	RefundID    uint    `json:"refund_id" gorm:"not null;index"`
+	OrderItemID uint    `json:"order_item_id" gorm:"not null;index"`
+	Quantity    int     `json:"quantity"`
+	Amount      float64 `json:"amount"`
+	Restock     bool    `json:"restock"`
+}
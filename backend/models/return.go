@@ -0,0 +1,110 @@
+package models
+
+import "time"
+
+const (
+	ReturnStatusRequested = "REQUESTED"
+	ReturnStatusApproved  = "APPROVED"
+	ReturnStatusRejected  = "REJECTED"
+	ReturnStatusReceived  = "RECEIVED"
+	ReturnStatusRefunded  = "REFUNDED"
+	ReturnStatusCancelled = "CANCELLED"
+)
+
+// returnTransitions lists the statuses a return may move to from each
+// status. REJECTED, REFUNDED and CANCELLED are terminal.
+var returnTransitions = map[string][]string{
+	ReturnStatusRequested: {ReturnStatusApproved, ReturnStatusRejected, ReturnStatusCancelled},
+	ReturnStatusApproved:  {ReturnStatusReceived},
+	ReturnStatusReceived:  {ReturnStatusRefunded},
+}
+
+// Condition of returned goods, recorded when they arrive.
+const (
+	ReturnConditionAsNew   = "AS_NEW"
+	ReturnConditionUsed    = "USED"
+	ReturnConditionDamaged = "DAMAGED"
+)
+
+// Disposition of returned goods: back into stock or written off.
+const (
+	ReturnDispositionRestock  = "RESTOCK"
+	ReturnDispositionWriteOff = "WRITE_OFF"
+)
+
+// ReturnRequest is a customer's request to send items of a delivered order
+// back (an RMA). Staff approve or reject it, record the goods when they
+// arrive and refund them.
+type ReturnRequest struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	OrderID uint   `json:"order_id" gorm:"not null;index"`
+	UserID  uint   `json:"user_id" gorm:"not null;index"`
+	Status  string `json:"status" gorm:"default:'REQUESTED';index"`
+	Reason  string `json:"reason"`
+	// RefundID is the refund made for the return once it is REFUNDED
+	RefundID   *uint         `json:"refund_id"`
+	Refund     *Refund       `json:"refund,omitempty" gorm:"foreignKey:RefundID"`
+	Items      []ReturnItem  `json:"items" gorm:"foreignKey:ReturnRequestID"`
+	Photos     []ReturnPhoto `json:"photos" gorm:"foreignKey:ReturnRequestID"`
+	Events     []ReturnEvent `json:"events,omitempty" gorm:"foreignKey:ReturnRequestID"`
+	ReceivedAt *time.Time    `json:"received_at"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// CanTransitionTo reports whether the return may legally move to status.
+func (r *ReturnRequest) CanTransitionTo(status string) bool {
+	for _, next := range returnTransitions[r.Status] {
+		if next == status {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidReturnStatus(status string) bool {
+	switch status {
+	case ReturnStatusRequested, ReturnStatusApproved, ReturnStatusRejected,
+		ReturnStatusReceived, ReturnStatusRefunded, ReturnStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// ReturnItem is a quantity of an order item being returned. The received
+// quantity, condition and disposition are filled in when the goods arrive.
+type ReturnItem struct {
+	ID               uint       `json:"id" gorm:"primaryKey"`
+	ReturnRequestID  uint       `json:"return_request_id" gorm:"not null;index"`
+	OrderItemID      uint       `json:"order_item_id" gorm:"not null;index"`
+	OrderItem        *OrderItem `json:"order_item,omitempty" gorm:"foreignKey:OrderItemID"`
+	Quantity         int        `json:"quantity"`
+	ReceivedQuantity int        `json:"received_quantity" gorm:"default:0"`
+	Condition        string     `json:"condition"`
+	Disposition      string     `json:"disposition"`
+}
+
+// ReturnPhoto is a photo the customer attached to a return, e.g. of damage.
+type ReturnPhoto struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	ReturnRequestID uint      `json:"return_request_id" gorm:"not null;index"`
+	Key             string    `json:"key" gorm:"uniqueIndex;not null"`
+	URL             string    `json:"url" gorm:"not null"`
+	ContentType     string    `json:"content_type"`
+	Size            int64     `json:"size"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ReturnEvent records every step of a return. ActorID is the customer or
+// staff member who took it.
+type ReturnEvent struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	ReturnRequestID uint      `json:"return_request_id" gorm:"index"`
+	FromStatus      string    `json:"from_status"`
+	ToStatus        string    `json:"to_status"`
+	ActorID         *uint     `json:"actor_id"`
+	Note            string    `json:"note"`
+	CreatedAt       time.Time `json:"created_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ReviewStatusPending  = "PENDING"
+	ReviewStatusApproved = "APPROVED"
+	ReviewStatusHidden   = "HIDDEN"
+)
+
+// Review is a customer's rating of a product. Only approved reviews are
+// shown publicly and counted in the product's rating.
+type Review struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	ProductID uint         `json:"product_id" gorm:"uniqueIndex:idx_reviews_product_user;not null"`
+	UserID    uint         `json:"user_id" gorm:"uniqueIndex:idx_reviews_product_user;not null"`
+	User      ReviewAuthor `json:"user" gorm:"foreignKey:UserID"`
+	Rating    int          `json:"rating" gorm:"not null"`
+	Comment   string       `json:"comment" gorm:"type:text"`
+	Status    string       `json:"status" gorm:"default:'PENDING';index"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// ReviewAuthor is the public view of the user who wrote a review.
+type ReviewAuthor struct {
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
+
+func (ReviewAuthor) TableName() string {
+	return "users"
+}
+
+func IsValidReviewStatus(status string) bool {
+	return status == ReviewStatusPending || status == ReviewStatusApproved || status == ReviewStatusHidden
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	RoleAdmin = "ADMIN"
+	RoleStaff = "STAFF"
+	RoleUser  = "USER"
+)
+
+const (
+	PermDashboardView   = "dashboard:view"
+	PermProductsWrite   = "products:write"
+	PermOrdersFulfil    = "orders:fulfil"
+	PermOrdersRefund    = "orders:refund"
+	PermUsersManage     = "users:manage"
+	PermVouchersManage  = "vouchers:manage"
+	PermBlogsPublish    = "blogs:publish"
+	PermRolesManage     = "roles:manage"
+	PermReviewsModerate = "reviews:moderate"
+	PermMediaManage     = "media:manage"
+	PermAuditView       = "audit:view"
+	PermPaymentsManage  = "payments:manage"
+	PermReturnsManage   = "returns:manage"
+)
+
+type Permission struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"uniqueIndex;not null"`
+	Description string `json:"description"`
+}
+
+// Role maps a User.Role name to the permissions it grants. The ADMIN role is
+// implicitly granted every permission.
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"uniqueIndex;not null"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// Permissions lists every permission known to the application.
+var Permissions = []Permission{
+	{Code: PermDashboardView, Description: "View dashboard statistics"},
+	{Code: PermProductsWrite, Description: "Create, edit and delete products"},
+	{Code: PermOrdersFulfil, Description: "View all orders and move them through fulfilment"},
+	{Code: PermOrdersRefund, Description: "Refund orders and retry failed refunds"},
+	{Code: PermUsersManage, Description: "Create, edit and delete users"},
+	{Code: PermVouchersManage, Description: "Create, edit and delete vouchers"},
+	{Code: PermBlogsPublish, Description: "Write and publish blog posts"},
+	{Code: PermRolesManage, Description: "Manage roles and their permissions"},
+	{Code: PermReviewsModerate, Description: "Approve and hide product reviews"},
+	{Code: PermMediaManage, Description: "Upload and delete files in the media library"},
+	{Code: PermAuditView, Description: "Read the audit log of admin changes"},
+	{Code: PermPaymentsManage, Description: "Inspect and replay payment gateway webhooks"},
+	{Code: PermReturnsManage, Description: "Approve, reject and receive customer returns"},
+}
+
+// DefaultRolePermissions is the permission set each built-in role starts
+// with. Admins may change it afterwards.
+var DefaultRolePermissions = map[string][]string{
+	RoleStaff: {PermDashboardView, PermProductsWrite, PermOrdersFulfil, PermBlogsPublish, PermReviewsModerate, PermMediaManage, PermReturnsManage},
+	RoleUser:  {},
+}
+
+func IsBuiltinRole(name string) bool {
+	return name == RoleAdmin || name == RoleStaff || name == RoleUser
+}
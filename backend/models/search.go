@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SearchQuery is a search made from the storefront product listing, kept
+// for the search analytics report.
+type SearchQuery struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Query       string    `json:"query" gorm:"not null"`
+	Normalized  string    `json:"normalized" gorm:"not null;index"`
+	ResultCount int64     `json:"result_count"`
+	CreatedAt   time.Time `json:"created_at" gorm:"index"`
+}
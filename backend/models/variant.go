@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ProductOption is a dimension a product comes in, such as colour or size,
+// together with the values it can take.
+type ProductOption struct {
+	ID        uint                 `json:"id" gorm:"primaryKey"`
+	ProductID uint                 `json:"product_id" gorm:"index;not null"`
+	Name      string               `json:"name" gorm:"not null"`
+	Position  int                  `json:"position"`
+	Values    []ProductOptionValue `json:"values" gorm:"foreignKey:OptionID"`
+}
+
+type ProductOptionValue struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	OptionID uint   `json:"option_id" gorm:"index;not null"`
+	Value    string `json:"value" gorm:"not null"`
+	Position int    `json:"position"`
+}
+
+// ProductVariant is one purchasable combination of option values, with its
+// own SKU and stock. Price overrides the product price when set.
+type ProductVariant struct {
+	ID           uint                 `json:"id" gorm:"primaryKey"`
+	ProductID    uint                 `json:"product_id" gorm:"index;not null"`
+	SKU          string               `json:"sku" gorm:"uniqueIndex;not null"`
+	Barcode      string               `json:"barcode"`
+	Price        *float64             `json:"price"`
+	Stock        int                  `json:"stock" gorm:"default:0"`
+	ImageURL     string               `json:"image_url"`
+	OptionValues []ProductOptionValue `json:"option_values" gorm:"many2many:variant_option_values"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt       `json:"-" gorm:"index"`
+}
+
+// PriceOr returns the variant's own price, or base when it has none.
+func (v *ProductVariant) PriceOr(base float64) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return base
+}
+
+// Title joins the variant's option values, e.g. "Black / L".
+func (v *ProductVariant) Title() string {
+	values := make([]string, 0, len(v.OptionValues))
+	for _, ov := range v.OptionValues {
+		values = append(values, ov.Value)
+	}
+	return strings.Join(values, " / ")
+}
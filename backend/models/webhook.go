@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+const (
+	WebhookStatusReceived  = "RECEIVED"
+	WebhookStatusProcessed = "PROCESSED"
+	WebhookStatusFailed    = "FAILED"
+	WebhookStatusRejected  = "REJECTED"
+	WebhookStatusDuplicate = "DUPLICATE"
+)
+
+const (
+	WebhookSourceReturn = "return"
+	WebhookSourceIPN    = "ipn"
+)
+
+// WebhookEvent is a callback received from a payment gateway, stored raw
+// before it is verified so that failures can be inspected and replayed.
+// EventID is the provider's ID of the result; only one event per ID is ever
+// processed; later deliveries of it are kept as duplicates.
+type WebhookEvent struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Provider    string     `json:"provider" gorm:"not null;index;uniqueIndex:idx_webhook_events_processed,where:status = 'PROCESSED'"`
+	EventID     string     `json:"event_id" gorm:"index;uniqueIndex:idx_webhook_events_processed"`
+	Source      string     `json:"source"`
+	Method      string     `json:"method"`
+	Query       string     `json:"query"`
+	Body        string     `json:"body" gorm:"type:text"`
+	RemoteIP    string     `json:"remote_ip"`
+	Status      string     `json:"status" gorm:"default:'RECEIVED';index"`
+	Error       string     `json:"error"`
+	Attempts    int        `json:"attempts"`
+	PaymentID   *uint      `json:"payment_id" gorm:"index"`
+	DuplicateOf *uint      `json:"duplicate_of"`
+	ProcessedAt *time.Time `json:"processed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
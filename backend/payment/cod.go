@@ -0,0 +1,31 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// COD is cash on delivery: nothing is paid up front and the payment is
+// collected by the courier.
+type COD struct{}
+
+func (COD) Name() string { return ProviderCOD }
+
+func (COD) Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	return &Checkout{}, nil
+}
+
+func (COD) ParseNotification(query url.Values, body []byte) (*Notification, error) {
+	return nil, ErrNotSupported
+}
+
+func (COD) Acknowledge(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Refund accepts every refund; the money is paid back outside the shop, in
+// cash or by bank transfer.
+func (COD) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{Message: "To be paid back in cash or by bank transfer"}, nil
+}
@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeShop serves the return and notify URLs of a shop using the Fake
+// provider and collects the notifications it accepts.
+func fakeShop(t *testing.T, provider *Fake) (*httptest.Server, chan *Notification) {
+	t.Helper()
+	notifications := make(chan *Notification, 1)
+	shop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n, err := provider.ParseNotification(r.URL.Query(), body)
+		if err == nil {
+			notifications <- n
+		}
+		provider.Acknowledge(w, err)
+	}))
+	t.Cleanup(shop.Close)
+	return shop, notifications
+}
+
+func fakeCheckout(t *testing.T, outcome string) (*Notification, url.Values, *Fake) {
+	t.Helper()
+	gateway := httptest.NewServer(NewFakeGateway("fake secret"))
+	t.Cleanup(gateway.Close)
+	provider := &Fake{GatewayURL: gateway.URL, Secret: "fake secret"}
+	shop, notifications := fakeShop(t, provider)
+
+	checkout, err := provider.Checkout(context.Background(), CheckoutRequest{
+		Reference:   "P7FAKE",
+		Amount:      150000,
+		Description: "Payment for order 7",
+		ReturnURL:   shop.URL + "/return",
+		NotifyURL:   shop.URL + "/ipn",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(checkout.RedirectURL + "&outcome=" + outcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("gateway answered %s, want a redirect", resp.Status)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, shop.URL+"/return?") {
+		t.Fatalf("redirected to %s", location)
+	}
+	returned, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case n := <-notifications:
+		return n, returned.Query(), provider
+	default:
+		t.Fatal("the gateway sent no notification")
+		return nil, nil, nil
+	}
+}
+
+func TestFakeGatewayPaidCheckout(t *testing.T) {
+	n, returned, provider := fakeCheckout(t, StatusPaid)
+	if n.Status != StatusPaid || n.Reference != "P7FAKE" || n.Amount != 150000 || n.TransactionID == "" {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+
+	// The customer comes back with the same, signed result
+	back, err := provider.ParseNotification(returned, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.EventID != n.EventID || back.Status != StatusPaid {
+		t.Fatalf("return %+v does not match notification %+v", back, n)
+	}
+}
+
+func TestFakeGatewayDeclinedCheckout(t *testing.T) {
+	n, _, _ := fakeCheckout(t, StatusFailed)
+	if n.Status != StatusFailed || n.Reference != "P7FAKE" {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+}
+
+func TestFakeGatewayRejectsTamperedCheckout(t *testing.T) {
+	gateway := httptest.NewServer(NewFakeGateway("fake secret"))
+	defer gateway.Close()
+	provider := &Fake{GatewayURL: gateway.URL, Secret: "fake secret"}
+	checkout, err := provider.Checkout(context.Background(), CheckoutRequest{Reference: "P7FAKE", Amount: 150000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(strings.Replace(checkout.RedirectURL, "amount=150000", "amount=1", 1) + "&outcome=paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("tampered checkout: %s, want 403", resp.Status)
+	}
+}
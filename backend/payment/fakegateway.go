@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FakeGateway is the payment page of the Fake provider. GET /pay shows the
+// payment with buttons to pay or decline it; POST /pay completes it the way a
+// real gateway would: the result is sent, signed, to the notify URL and the
+// customer is redirected to the return URL with it. Adding outcome=paid or
+// outcome=failed to the GET skips the page, for scripted tests.
+type FakeGateway struct {
+	Secret string
+	Client *http.Client
+}
+
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{Secret: secret, Client: httpClient}
+}
+
+var fakePayPage = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Fake payment gateway</title></head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 4em auto">
+<h1>Fake payment gateway</h1>
+<p>{{.Description}}</p>
+<p><strong>{{.Amount}} VND</strong> (reference {{.Reference}})</p>
+<form method="post" action="pay">
+{{range $key, $values := .Params}}{{range $values}}<input type="hidden" name="{{$key}}" value="{{.}}">
+{{end}}{{end}}<button name="outcome" value="paid">Pay</button>
+<button name="outcome" value="failed">Decline</button>
+</form>
+</body>
+</html>
+`))
+
+func (g *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") != "/pay" {
+		http.NotFound(w, r)
+		return
+	}
+	var params url.Values
+	switch r.Method {
+	case http.MethodGet:
+		params = r.URL.Query()
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		params = r.PostForm
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	outcome := params.Get("outcome")
+	params.Del("outcome")
+	if !validSignature(fakeSignature(g.Secret, params), params.Get("signature")) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	if outcome == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fakePayPage.Execute(w, map[string]interface{}{
+			"Reference":   params.Get("reference"),
+			"Amount":      params.Get("amount"),
+			"Description": params.Get("description"),
+			"Params":      params,
+		})
+		return
+	}
+	g.complete(w, r, params, outcome == StatusPaid)
+}
+
+func (g *FakeGateway) complete(w http.ResponseWriter, r *http.Request, params url.Values, paid bool) {
+	txn := make([]byte, 8)
+	rand.Read(txn)
+	result := url.Values{
+		"reference":      {params.Get("reference")},
+		"transaction_id": {"FAKE" + strings.ToUpper(hex.EncodeToString(txn))},
+		"amount":         {params.Get("amount")},
+		"status":         {StatusFailed},
+		"message":        {"Declined by the customer"},
+	}
+	if paid {
+		result.Set("status", StatusPaid)
+		result.Set("message", "Paid")
+	}
+	result.Set("signature", fakeSignature(g.Secret, result))
+
+	if notifyURL := params.Get("notify_url"); notifyURL != "" {
+		resp, err := g.Client.PostForm(notifyURL, result)
+		if err != nil {
+			log.Println("Fake gateway could not deliver notification:", err)
+		} else {
+			resp.Body.Close()
+		}
+	}
+
+	returnURL := params.Get("return_url")
+	if returnURL == "" {
+		w.Write([]byte("Payment " + result.Get("status")))
+		return
+	}
+	separator := "?"
+	if strings.Contains(returnURL, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, returnURL+separator+result.Encode(), http.StatusSeeOther)
+}
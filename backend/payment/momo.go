@@ -0,0 +1,196 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MoMo takes payments through the MoMo e-wallet (All-in-one API v2).
+type MoMo struct {
+	PartnerCode string
+	AccessKey   string
+	SecretKey   string
+	Endpoint    string
+}
+
+// momoNotificationFields are the fields of a MoMo result in the order they
+// are signed.
+var momoNotificationFields = []string{
+	"amount", "extraData", "message", "orderId", "orderInfo", "orderType", "partnerCode",
+	"payType", "requestId", "responseTime", "resultCode", "transId",
+}
+
+func (p *MoMo) Name() string { return ProviderMoMo }
+
+func (p *MoMo) Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	amount := strconv.FormatInt(wholeAmount(req.Amount), 10)
+	const requestType = "captureWallet"
+	raw := "accessKey=" + p.AccessKey +
+		"&amount=" + amount +
+		"&extraData=" +
+		"&ipnUrl=" + req.NotifyURL +
+		"&orderId=" + req.Reference +
+		"&orderInfo=" + req.Description +
+		"&partnerCode=" + p.PartnerCode +
+		"&redirectUrl=" + req.ReturnURL +
+		"&requestId=" + req.Reference +
+		"&requestType=" + requestType
+	var result struct {
+		ResultCode int    `json:"resultCode"`
+		Message    string `json:"message"`
+		PayURL     string `json:"payUrl"`
+	}
+	err := p.post(ctx, "/v2/gateway/api/create", map[string]interface{}{
+		"partnerCode": p.PartnerCode,
+		"requestId":   req.Reference,
+		"amount":      wholeAmount(req.Amount),
+		"orderId":     req.Reference,
+		"orderInfo":   req.Description,
+		"redirectUrl": req.ReturnURL,
+		"ipnUrl":      req.NotifyURL,
+		"requestType": requestType,
+		"extraData":   "",
+		"lang":        "vi",
+		"signature":   sign(sha256.New, p.SecretKey, raw),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.ResultCode != 0 || result.PayURL == "" {
+		return nil, fmt.Errorf("momo: result code %d: %s", result.ResultCode, result.Message)
+	}
+	return &Checkout{RedirectURL: result.PayURL}, nil
+}
+
+// ParseNotification reads a MoMo result, sent as JSON to the IPN URL and as
+// query parameters to the redirect URL.
+func (p *MoMo) ParseNotification(query url.Values, body []byte) (*Notification, error) {
+	fields := map[string]string{}
+	if len(body) > 0 {
+		var values map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return nil, errors.New("momo: invalid notification body")
+		}
+		for key, value := range values {
+			fields[key] = fmt.Sprint(value)
+		}
+	} else {
+		for key := range query {
+			fields[key] = query.Get(key)
+		}
+	}
+
+	raw := "accessKey=" + p.AccessKey
+	for _, field := range momoNotificationFields {
+		raw += "&" + field + "=" + fields[field]
+	}
+	if !validSignature(sign(sha256.New, p.SecretKey, raw), fields["signature"]) {
+		return nil, ErrInvalidSignature
+	}
+	if fields["partnerCode"] != p.PartnerCode {
+		return nil, ErrInvalidSignature
+	}
+
+	amount, err := strconv.ParseInt(fields["amount"], 10, 64)
+	if err != nil {
+		return nil, errors.New("momo: invalid amount")
+	}
+	n := &Notification{
+		EventID:       fields["requestId"] + ":" + fields["transId"] + ":" + fields["resultCode"],
+		Reference:     fields["orderId"],
+		TransactionID: fields["transId"],
+		Amount:        float64(amount),
+		Status:        StatusFailed,
+		Message:       fields["message"],
+	}
+	switch fields["resultCode"] {
+	case "0":
+		n.Status = StatusPaid
+	case "1000", "7000", "7002", "9000":
+		// Initiated, being processed or authorised but not yet captured
+		n.Status = StatusPending
+	}
+	return n, nil
+}
+
+// Refund calls the MoMo refund API, which takes the refund's own order ID
+// and the MoMo transaction ID of the payment.
+func (p *MoMo) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	transID, err := strconv.ParseInt(req.TransactionID, 10, 64)
+	if err != nil {
+		return nil, errors.New("momo: payment has no MoMo transaction ID")
+	}
+	amount := strconv.FormatInt(wholeAmount(req.Amount), 10)
+	raw := "accessKey=" + p.AccessKey +
+		"&amount=" + amount +
+		"&description=" + req.Reason +
+		"&orderId=" + req.Reference +
+		"&partnerCode=" + p.PartnerCode +
+		"&requestId=" + req.Reference +
+		"&transId=" + req.TransactionID
+	var result struct {
+		ResultCode int    `json:"resultCode"`
+		Message    string `json:"message"`
+		TransID    int64  `json:"transId"`
+	}
+	err = p.post(ctx, "/v2/gateway/api/refund", map[string]interface{}{
+		"partnerCode": p.PartnerCode,
+		"orderId":     req.Reference,
+		"requestId":   req.Reference,
+		"amount":      wholeAmount(req.Amount),
+		"transId":     transID,
+		"lang":        "vi",
+		"description": req.Reason,
+		"signature":   sign(sha256.New, p.SecretKey, raw),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.ResultCode != 0 {
+		return nil, fmt.Errorf("momo: result code %d: %s", result.ResultCode, result.Message)
+	}
+	return &RefundResult{TransactionID: strconv.FormatInt(result.TransID, 10), Message: result.Message}, nil
+}
+
+// post sends a JSON request to the MoMo API and decodes its response.
+func (p *MoMo) post(ctx context.Context, path string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(p.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("momo: %s: %w", resp.Status, err)
+	}
+	return nil
+}
+
+// Acknowledge answers 204 No Content, which is all MoMo expects from an IPN
+// handler.
+func (p *MoMo) Acknowledge(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidSignature) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func testMoMo() *MoMo {
+	return &MoMo{PartnerCode: "MOMOTEST", AccessKey: "ACCESSKEY", SecretKey: "MOMOSECRET"}
+}
+
+// momoResult is a MoMo IPN body, signed with HMAC-SHA256 over its fields in
+// the order MoMo documents.
+func momoResult(secretKey, resultCode string) map[string]interface{} {
+	result := map[string]interface{}{
+		"partnerCode":  "MOMOTEST",
+		"orderId":      "P42ABC",
+		"requestId":    "P42ABC",
+		"amount":       json.Number("100000"),
+		"orderInfo":    "Payment for order 42",
+		"orderType":    "momo_wallet",
+		"transId":      json.Number("4088878653"),
+		"resultCode":   json.Number(resultCode),
+		"message":      "Thành công.",
+		"payType":      "qr",
+		"responseTime": json.Number("1721720663942"),
+		"extraData":    "",
+	}
+	raw := "accessKey=ACCESSKEY&amount=100000&extraData=&message=Thành công.&orderId=P42ABC" +
+		"&orderInfo=Payment for order 42&orderType=momo_wallet&partnerCode=MOMOTEST&payType=qr" +
+		"&requestId=P42ABC&responseTime=1721720663942&resultCode=" + resultCode + "&transId=4088878653"
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(raw))
+	result["signature"] = hex.EncodeToString(mac.Sum(nil))
+	return result
+}
+
+func momoBody(t *testing.T, result map[string]interface{}) []byte {
+	t.Helper()
+	body, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestMoMoParseNotification(t *testing.T) {
+	p := testMoMo()
+
+	n, err := p.ParseNotification(nil, momoBody(t, momoResult(p.SecretKey, "0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != StatusPaid || n.Reference != "P42ABC" || n.TransactionID != "4088878653" || n.Amount != 100000 {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+
+	// The redirect URL gets the same fields as query parameters
+	query := url.Values{}
+	for key, value := range momoResult(p.SecretKey, "0") {
+		query.Set(key, toString(value))
+	}
+	if n, err := p.ParseNotification(query, nil); err != nil || n.Status != StatusPaid {
+		t.Fatalf("redirect: %+v, %v", n, err)
+	}
+
+	for code, want := range map[string]string{"9000": StatusPending, "1006": StatusFailed} {
+		n, err := p.ParseNotification(nil, momoBody(t, momoResult(p.SecretKey, code)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Status != want {
+			t.Errorf("result code %s: status %q, want %q", code, n.Status, want)
+		}
+	}
+}
+
+func TestMoMoRejectsBadSignatures(t *testing.T) {
+	p := testMoMo()
+
+	tampered := momoResult(p.SecretKey, "0")
+	tampered["amount"] = json.Number("1000")
+	otherPartner := &MoMo{PartnerCode: "OTHER", AccessKey: p.AccessKey, SecretKey: p.SecretKey}
+
+	if _, err := p.ParseNotification(nil, momoBody(t, tampered)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered amount: got %v, want ErrInvalidSignature", err)
+	}
+	if _, err := p.ParseNotification(nil, momoBody(t, momoResult("another secret", "0"))); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong secret: got %v, want ErrInvalidSignature", err)
+	}
+	if _, err := otherPartner.ParseNotification(nil, momoBody(t, momoResult(p.SecretKey, "0"))); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("another partner: got %v, want ErrInvalidSignature", err)
+	}
+}
+
+func toString(value interface{}) string {
+	if number, ok := value.(json.Number); ok {
+		return number.String()
+	}
+	return value.(string)
+}
@@ -0,0 +1,204 @@
+// Package payment takes payments for orders through payment gateways.
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	ProviderCOD   = "cod"
+	ProviderVNPay = "vnpay"
+	ProviderMoMo  = "momo"
+	ProviderFake  = "fake"
+)
+
+// Outcomes of a payment reported by a gateway.
+const (
+	StatusPaid    = "paid"
+	StatusFailed  = "failed"
+	StatusPending = "pending"
+)
+
+var (
+	ErrInvalidSignature = errors.New("payment: invalid signature")
+	ErrNotSupported     = errors.New("payment: not supported by this provider")
+	// The errors below are not returned by providers; handlers pass them to
+	// Acknowledge so that the gateway gets the answer it expects.
+	ErrUnknownPayment = errors.New("payment: unknown payment")
+	ErrAmountMismatch = errors.New("payment: amount does not match")
+)
+
+// CheckoutRequest asks a gateway to collect Amount for one payment attempt.
+type CheckoutRequest struct {
+	// Reference identifies the attempt at the gateway and comes back in its
+	// notifications
+	Reference   string
+	Amount      float64
+	Description string
+	// ReturnURL is where the gateway sends the customer back to
+	ReturnURL string
+	// NotifyURL receives the gateway's server-to-server notification (IPN)
+	NotifyURL string
+	ClientIP  string
+	// CreatedAt is when the payment was created; VNPay asks for it again
+	// when the payment is refunded
+	CreatedAt time.Time
+}
+
+// Checkout is the started payment. RedirectURL is empty when nothing has to
+// be paid up front.
+type Checkout struct {
+	RedirectURL string
+}
+
+// Notification is a payment result sent by a gateway, either with the
+// customer coming back from it or server to server. It is only returned once
+// its signature has been verified.
+type Notification struct {
+	// EventID identifies the notification; a gateway delivering the same
+	// result again, or to both the return and the IPN URL, sends the same ID
+	EventID       string
+	Reference     string
+	TransactionID string
+	Amount        float64
+	Status        string
+	Message       string
+}
+
+// RefundRequest asks a gateway to pay Amount of a payment back.
+type RefundRequest struct {
+	// Reference identifies the refund at the gateway
+	Reference string
+	Amount    float64
+	Reason    string
+	// The payment being refunded
+	PaymentReference string
+	TransactionID    string
+	PaymentAmount    float64
+	PaymentCreatedAt time.Time
+	// RequestedBy names who asked for the refund
+	RequestedBy string
+	ClientIP    string
+}
+
+// RefundResult is a refund the gateway accepted.
+type RefundResult struct {
+	TransactionID string
+	Message       string
+}
+
+// PaymentProvider is a way of paying for an order.
+type PaymentProvider interface {
+	Name() string
+	// Checkout starts a payment and returns where to send the customer.
+	Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error)
+	// ParseNotification verifies and parses a return or IPN request from its
+	// query string and body. It returns ErrInvalidSignature for forged or
+	// tampered requests.
+	ParseNotification(query url.Values, body []byte) (*Notification, error)
+	// Acknowledge answers an IPN request in the format the gateway expects.
+	// err is nil when the notification was processed.
+	Acknowledge(w http.ResponseWriter, err error)
+	// Refund pays all or part of a payment back. It returns an error when
+	// the gateway did not accept the refund.
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+}
+
+// FromEnv builds the enabled providers from the environment. Cash on
+// delivery is always available; VNPay and MoMo are enabled when their
+// merchant credentials are set, and the fake gateway when PAYMENT_FAKE is
+// true.
+func FromEnv() map[string]PaymentProvider {
+	providers := map[string]PaymentProvider{
+		ProviderCOD: COD{},
+	}
+	if os.Getenv("VNPAY_TMN_CODE") != "" {
+		providers[ProviderVNPay] = &VNPay{
+			TmnCode:    os.Getenv("VNPAY_TMN_CODE"),
+			HashSecret: os.Getenv("VNPAY_HASH_SECRET"),
+			PayURL:     getEnv("VNPAY_PAY_URL", "https://sandbox.vnpayment.vn/paymentv2/vpcpay.html"),
+			APIURL:     getEnv("VNPAY_API_URL", "https://sandbox.vnpayment.vn/merchant_webapi/api/transaction"),
+		}
+	}
+	if os.Getenv("MOMO_PARTNER_CODE") != "" {
+		providers[ProviderMoMo] = &MoMo{
+			PartnerCode: os.Getenv("MOMO_PARTNER_CODE"),
+			AccessKey:   os.Getenv("MOMO_ACCESS_KEY"),
+			SecretKey:   os.Getenv("MOMO_SECRET_KEY"),
+			Endpoint:    getEnv("MOMO_ENDPOINT", "https://test-payment.momo.vn"),
+		}
+	}
+	if os.Getenv("PAYMENT_FAKE") == "true" {
+		gatewayURL := getEnv("FAKE_GATEWAY_URL", getEnv("API_URL", "http://localhost:8080")+"/fake-gateway")
+		log.Printf("Fake payments are enabled through %s", gatewayURL)
+		providers[ProviderFake] = &Fake{
+			GatewayURL: gatewayURL,
+			Secret:     getEnv("FAKE_PAYMENT_SECRET", "fake-payment-secret"),
+		}
+	}
+	return providers
+}
+
+// NewReference returns a unique, alphanumeric payment reference for an
+// order, which every gateway accepts as its order or transaction ID.
+func NewReference(orderID uint) (string, error) {
+	return newReference(orderID, "P")
+}
+
+// NewRefundReference is NewReference for refunds.
+func NewRefundReference(orderID uint) (string, error) {
+	return newReference(orderID, "R")
+}
+
+func newReference(orderID uint, kind string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(orderID), 10) + kind + hex.EncodeToString(b), nil
+}
+
+// wholeAmount is an amount in whole dong, as gateways take it.
+func wholeAmount(amount float64) int64 {
+	return int64(math.Round(amount))
+}
+
+func sign(newHash func() hash.Hash, secret, message string) string {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature compares hex signatures in constant time, ignoring case.
+func validSignature(expected, got string) bool {
+	want, err := hex.DecodeString(expected)
+	if err != nil {
+		return false
+	}
+	have, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(want, have)
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,218 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vnpayZone is the time zone VNPay expects dates in.
+var vnpayZone = time.FixedZone("ICT", 7*60*60)
+
+const vnpayPaymentTTL = 15 * time.Minute
+
+// VNPay takes payments through the VNPay payment gateway (API 2.1.0). Its
+// IPN URL is configured in the merchant portal rather than per payment.
+type VNPay struct {
+	TmnCode    string
+	HashSecret string
+	PayURL     string
+	// APIURL is the merchant API used for refunds
+	APIURL string
+}
+
+func (p *VNPay) Name() string { return ProviderVNPay }
+
+func (p *VNPay) Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	now := time.Now().In(vnpayZone)
+	if !req.CreatedAt.IsZero() {
+		now = req.CreatedAt.In(vnpayZone)
+	}
+	params := url.Values{
+		"vnp_Version":    {"2.1.0"},
+		"vnp_Command":    {"pay"},
+		"vnp_TmnCode":    {p.TmnCode},
+		"vnp_Amount":     {strconv.FormatInt(wholeAmount(req.Amount)*100, 10)},
+		"vnp_CurrCode":   {"VND"},
+		"vnp_TxnRef":     {req.Reference},
+		"vnp_OrderInfo":  {req.Description},
+		"vnp_OrderType":  {"other"},
+		"vnp_Locale":     {"vn"},
+		"vnp_ReturnUrl":  {req.ReturnURL},
+		"vnp_IpAddr":     {req.ClientIP},
+		"vnp_CreateDate": {now.Format("20060102150405")},
+		"vnp_ExpireDate": {time.Now().In(vnpayZone).Add(vnpayPaymentTTL).Format("20060102150405")},
+	}
+	query := vnpayQuery(params)
+	signature := sign(sha512.New, p.HashSecret, query)
+	return &Checkout{RedirectURL: p.PayURL + "?" + query + "&vnp_SecureHash=" + signature}, nil
+}
+
+// ParseNotification reads the vnp_ parameters VNPay sends both to the return
+// URL and to the IPN URL.
+func (p *VNPay) ParseNotification(query url.Values, body []byte) (*Notification, error) {
+	params := url.Values{}
+	for key, values := range query {
+		if strings.HasPrefix(key, "vnp_") && key != "vnp_SecureHash" && key != "vnp_SecureHashType" {
+			params[key] = values
+		}
+	}
+	if !validSignature(sign(sha512.New, p.HashSecret, vnpayQuery(params)), query.Get("vnp_SecureHash")) {
+		return nil, ErrInvalidSignature
+	}
+	if params.Get("vnp_TmnCode") != p.TmnCode {
+		return nil, ErrInvalidSignature
+	}
+
+	amount, err := strconv.ParseInt(params.Get("vnp_Amount"), 10, 64)
+	if err != nil {
+		return nil, errors.New("vnpay: invalid amount")
+	}
+	n := &Notification{
+		EventID:       params.Get("vnp_TxnRef") + ":" + params.Get("vnp_TransactionNo") + ":" + params.Get("vnp_ResponseCode"),
+		Reference:     params.Get("vnp_TxnRef"),
+		TransactionID: params.Get("vnp_TransactionNo"),
+		Amount:        float64(amount) / 100,
+		Status:        StatusFailed,
+		Message:       "VNPay response code " + params.Get("vnp_ResponseCode"),
+	}
+	if params.Get("vnp_ResponseCode") == "00" && params.Get("vnp_TransactionStatus") == "00" {
+		n.Status = StatusPaid
+	}
+	return n, nil
+}
+
+// Acknowledge answers with the RspCode VNPay documents for its IPN; VNPay
+// retries notifications that are not confirmed.
+func (p *VNPay) Acknowledge(w http.ResponseWriter, err error) {
+	code, message := "00", "Confirm Success"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrInvalidSignature):
+		code, message = "97", "Invalid Checksum"
+	case errors.Is(err, ErrUnknownPayment):
+		code, message = "01", "Order not found"
+	case errors.Is(err, ErrAmountMismatch):
+		code, message = "04", "Invalid amount"
+	default:
+		code, message = "99", "Unknown error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"RspCode": code, "Message": message})
+}
+
+// Refund calls the refund command of the VNPay merchant API. The payment's
+// creation time must match the vnp_CreateDate it was sent with.
+func (p *VNPay) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	transactionType := "03"
+	if wholeAmount(req.Amount) == wholeAmount(req.PaymentAmount) {
+		transactionType = "02"
+	}
+	createdBy := req.RequestedBy
+	if createdBy == "" {
+		createdBy = "system"
+	}
+	fields := []string{
+		req.Reference,
+		"2.1.0",
+		"refund",
+		p.TmnCode,
+		transactionType,
+		req.PaymentReference,
+		strconv.FormatInt(wholeAmount(req.Amount)*100, 10),
+		req.TransactionID,
+		req.PaymentCreatedAt.In(vnpayZone).Format("20060102150405"),
+		createdBy,
+		time.Now().In(vnpayZone).Format("20060102150405"),
+		req.ClientIP,
+		req.Reason,
+	}
+	body, err := json.Marshal(map[string]string{
+		"vnp_RequestId":       fields[0],
+		"vnp_Version":         fields[1],
+		"vnp_Command":         fields[2],
+		"vnp_TmnCode":         fields[3],
+		"vnp_TransactionType": fields[4],
+		"vnp_TxnRef":          fields[5],
+		"vnp_Amount":          fields[6],
+		"vnp_TransactionNo":   fields[7],
+		"vnp_TransactionDate": fields[8],
+		"vnp_CreateBy":        fields[9],
+		"vnp_CreateDate":      fields[10],
+		"vnp_IpAddr":          fields[11],
+		"vnp_OrderInfo":       fields[12],
+		"vnp_SecureHash":      sign(sha512.New, p.HashSecret, strings.Join(fields, "|")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("vnpay: %s: %w", resp.Status, err)
+	}
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		if value != nil {
+			result[key] = fmt.Sprint(value)
+		}
+	}
+	signed := []string{
+		result["vnp_ResponseId"], result["vnp_Command"], result["vnp_ResponseCode"], result["vnp_Message"],
+		result["vnp_TmnCode"], result["vnp_TxnRef"], result["vnp_Amount"], result["vnp_BankCode"],
+		result["vnp_PayDate"], result["vnp_TransactionNo"], result["vnp_TransactionType"],
+		result["vnp_TransactionStatus"], result["vnp_OrderInfo"],
+	}
+	if !validSignature(sign(sha512.New, p.HashSecret, strings.Join(signed, "|")), result["vnp_SecureHash"]) {
+		return nil, ErrInvalidSignature
+	}
+	if result["vnp_ResponseCode"] != "00" {
+		return nil, fmt.Errorf("vnpay: response code %s: %s", result["vnp_ResponseCode"], result["vnp_Message"])
+	}
+	return &RefundResult{TransactionID: result["vnp_TransactionNo"], Message: result["vnp_Message"]}, nil
+}
+
+// vnpayQuery encodes params sorted by key the way VNPay signs them, which is
+// PHP's urlencode.
+func vnpayQuery(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := params.Get(key)
+		if value == "" {
+			continue
+		}
+		parts = append(parts, phpURLEncode(key)+"="+phpURLEncode(value))
+	}
+	return strings.Join(parts, "&")
+}
+
+func phpURLEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "~", "%7E")
+}
@@ -0,0 +1,128 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testVNPay() *VNPay {
+	return &VNPay{TmnCode: "DEMO1234", HashSecret: "VNPAYSECRET", PayURL: "https://sandbox.vnpayment.vn/paymentv2/vpcpay.html"}
+}
+
+// signVNPay signs params the way the VNPay sandbox does: HMAC-SHA512 of the
+// sorted, URL-encoded parameters.
+func signVNPay(secret string, params url.Values) url.Values {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(params.Encode()))
+	signed := url.Values{}
+	for key, values := range params {
+		signed[key] = values
+	}
+	signed.Set("vnp_SecureHashType", "HmacSHA512")
+	signed.Set("vnp_SecureHash", strings.ToUpper(hex.EncodeToString(mac.Sum(nil))))
+	return signed
+}
+
+func vnpayResult(responseCode string) url.Values {
+	return url.Values{
+		"vnp_Amount":            {"10000000"},
+		"vnp_BankCode":          {"NCB"},
+		"vnp_OrderInfo":         {"Payment for order 42"},
+		"vnp_PayDate":           {"20240101120000"},
+		"vnp_ResponseCode":      {responseCode},
+		"vnp_TmnCode":           {"DEMO1234"},
+		"vnp_TransactionNo":     {"14000000"},
+		"vnp_TransactionStatus": {responseCode},
+		"vnp_TxnRef":            {"P42ABC"},
+	}
+}
+
+func TestVNPayParseNotification(t *testing.T) {
+	p := testVNPay()
+
+	n, err := p.ParseNotification(signVNPay(p.HashSecret, vnpayResult("00")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != StatusPaid || n.Reference != "P42ABC" || n.TransactionID != "14000000" || n.Amount != 100000 {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+	if n.EventID != "P42ABC:14000000:00" {
+		t.Fatalf("EventID = %q", n.EventID)
+	}
+
+	n, err = p.ParseNotification(signVNPay(p.HashSecret, vnpayResult("24")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != StatusFailed {
+		t.Fatalf("cancelled payment has status %q", n.Status)
+	}
+}
+
+func TestVNPayRejectsBadSignatures(t *testing.T) {
+	p := testVNPay()
+
+	tampered := signVNPay(p.HashSecret, vnpayResult("00"))
+	tampered.Set("vnp_Amount", "100")
+	otherMerchant := vnpayResult("00")
+	otherMerchant.Set("vnp_TmnCode", "OTHER")
+	unsigned := vnpayResult("00")
+
+	tests := map[string]url.Values{
+		"tampered amount":   tampered,
+		"wrong secret":      signVNPay("another secret", vnpayResult("00")),
+		"another merchant":  signVNPay(p.HashSecret, otherMerchant),
+		"missing signature": unsigned,
+	}
+	for name, query := range tests {
+		if _, err := p.ParseNotification(query, nil); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestVNPayCheckoutIsSigned(t *testing.T) {
+	p := testVNPay()
+	created := time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)
+	checkout, err := p.Checkout(context.Background(), CheckoutRequest{
+		Reference:   "P42ABC",
+		Amount:      100000,
+		Description: "Payment for order 42",
+		ReturnURL:   "http://localhost:8080/payments/vnpay/return",
+		ClientIP:    "127.0.0.1",
+		CreatedAt:   created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, signature, ok := strings.Cut(strings.TrimPrefix(checkout.RedirectURL, p.PayURL+"?"), "&vnp_SecureHash=")
+	if !ok {
+		t.Fatalf("redirect URL has no signature: %s", checkout.RedirectURL)
+	}
+	mac := hmac.New(sha512.New, []byte(p.HashSecret))
+	mac.Write([]byte(query))
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Fatalf("signature = %s, want %s", signature, want)
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Get("vnp_Amount") != "10000000" || params.Get("vnp_TxnRef") != "P42ABC" || params.Get("vnp_TmnCode") != "DEMO1234" {
+		t.Fatalf("unexpected parameters: %v", params)
+	}
+	// Dates are in Vietnam time
+	if params.Get("vnp_CreateDate") != "20240101120000" {
+		t.Fatalf("vnp_CreateDate = %s", params.Get("vnp_CreateDate"))
+	}
+}
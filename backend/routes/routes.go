@@ -3,6 +3,7 @@ package routes
 import (
 	"ecommerce-backend/handlers"
 	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,24 +14,65 @@ func SetupRoutes(r *gin.Engine) {
 	{
 		auth.POST("/register", handlers.Register)
 		auth.POST("/login", handlers.Login)
+		auth.POST("/refresh", handlers.Refresh)
+		auth.POST("/logout", handlers.Logout)
+		auth.POST("/forgot-password", handlers.ForgotPassword)
+		auth.POST("/reset-password", handlers.ResetPassword)
+		auth.POST("/verify-email", handlers.VerifyEmail)
+		auth.POST("/resend-verification", handlers.ResendVerification)
 	}
 
 	// Public product routes
 	r.GET("/api/products", handlers.GetProducts)
 	r.GET("/api/products/:id", handlers.GetProduct)
+	r.GET("/api/products/:id/reviews", handlers.GetProductReviews)
+
+	// Public category routes
+	r.GET("/api/categories", handlers.GetCategoryTree)
+
+	// Public search routes
+	r.GET("/api/search/suggest", handlers.SearchSuggest)
 
 	// Public blog routes
 	r.GET("/api/blogs", handlers.GetPublishedBlogs)
 	r.GET("/api/blogs/:id", handlers.GetPublishedBlog)
 
+	// Payment routes; gateways send customers and notifications back here
+	r.GET("/api/payment-methods", handlers.GetPaymentMethods)
+	r.GET("/payments/:provider/return", handlers.PaymentReturn)
+	r.GET("/payments/:provider/ipn", handlers.PaymentNotify)
+	r.POST("/payments/:provider/ipn", handlers.PaymentNotify)
+
 	// Protected routes
 	api := r.Group("/api")
 	api.Use(middleware.AuthMiddleware())
 	{
+		// Account routes
+		me := api.Group("/me")
+		{
+			me.GET("", handlers.GetMe)
+			me.PUT("", handlers.UpdateMe)
+			me.PUT("/password", handlers.ChangePassword)
+			me.DELETE("", handlers.DeleteMe)
+		}
+
+		// Address book routes
+		addresses := api.Group("/addresses")
+		{
+			addresses.GET("", handlers.GetAddresses)
+			addresses.POST("", handlers.CreateAddress)
+			addresses.PUT("/:id", handlers.UpdateAddress)
+			addresses.PUT("/:id/default", handlers.SetDefaultAddress)
+			addresses.DELETE("/:id", handlers.DeleteAddress)
+		}
+
+		// Review routes
+		api.POST("/products/:id/reviews", handlers.CreateReview)
+
 		// Product management routes
-		products := api.Group("/products")
+		products := api.Group("/products", middleware.RequirePermission(models.PermProductsWrite))
 		{
-			products.POST("", handlers.CreateProduct)
+			products.POST("", middleware.Idempotent(), handlers.CreateProduct)
 			products.PUT("/:id", handlers.UpdateProduct)
 			products.DELETE("/:id", handlers.DeleteProduct)
 		}
@@ -39,48 +81,175 @@ func SetupRoutes(r *gin.Engine) {
 		cart := api.Group("/cart")
 		{
 			cart.GET("", handlers.GetCart)
-			cart.POST("/add", handlers.AddToCart)
+			cart.POST("/add", middleware.Idempotent(), handlers.AddToCart)
 			cart.PUT("/item/:itemId", handlers.UpdateCartItem)
 			cart.DELETE("/item/:itemId", handlers.RemoveFromCart)
 		}
 
+		// Voucher routes
+		api.POST("/vouchers/validate", handlers.ValidateVoucher)
+
 		// Order routes
 		orders := api.Group("/orders")
 		{
-			orders.POST("", handlers.CreateOrder)
+			orders.POST("", middleware.Idempotent(), handlers.CreateOrder)
 			orders.GET("", handlers.GetOrders)
 			orders.GET("/:id", handlers.GetOrder)
+			orders.POST("/:id/pay", middleware.Idempotent(), handlers.PayOrder)
+			orders.POST("/:id/cancel", handlers.CancelOrder)
+			orders.POST("/:id/returns", middleware.IdempotentUpTo(handlers.MaxReturnRequestSize), handlers.CreateReturn)
+		}
+
+		// Return routes
+		returns := api.Group("/returns")
+		{
+			returns.GET("", handlers.GetMyReturns)
+			returns.GET("/:id", handlers.GetMyReturn)
+			returns.POST("/:id/cancel", handlers.CancelReturn)
 		}
 
-		// Admin routes
+		// Admin routes, each guarded by the permission it needs
 		admin := api.Group("/admin")
-		admin.Use(middleware.AdminMiddleware())
 		{
-			admin.GET("/dashboard", handlers.GetDashboardStats)
+			admin.GET("/dashboard", middleware.RequirePermission(models.PermDashboardView), handlers.GetDashboardStats)
+			admin.GET("/search/report", middleware.RequirePermission(models.PermDashboardView), handlers.GetSearchReport)
+			admin.GET("/audit-logs", middleware.RequirePermission(models.PermAuditView), handlers.GetAuditLogs)
 
 			// Users
-			admin.GET("/users", handlers.GetUsers)
-			admin.POST("/users", handlers.CreateUser)
-			admin.PUT("/users/:id", handlers.UpdateUser)
-			admin.DELETE("/users/:id", handlers.DeleteUser)
+			adminUsers := admin.Group("/users", middleware.RequirePermission(models.PermUsersManage))
+			{
+				adminUsers.GET("", handlers.GetUsers)
+				adminUsers.POST("", middleware.Idempotent(), handlers.CreateUser)
+				adminUsers.PUT("/:id", handlers.UpdateUser)
+				adminUsers.DELETE("/:id", handlers.DeleteUser)
+				adminUsers.GET("/trash", handlers.GetTrashedUsers)
+				adminUsers.POST("/:id/restore", handlers.RestoreUser)
+				adminUsers.DELETE("/:id/purge", handlers.PurgeUser)
+			}
+
+			// Roles & permissions
+			adminRoles := admin.Group("", middleware.RequirePermission(models.PermRolesManage))
+			{
+				adminRoles.GET("/permissions", handlers.GetPermissions)
+				adminRoles.GET("/roles", handlers.GetRoles)
+				adminRoles.POST("/roles", middleware.Idempotent(), handlers.CreateRole)
+				adminRoles.PUT("/roles/:id", handlers.UpdateRole)
+				adminRoles.DELETE("/roles/:id", handlers.DeleteRole)
+			}
+
+			// Payment gateway webhooks
+			adminWebhooks := admin.Group("/webhooks", middleware.RequirePermission(models.PermPaymentsManage))
+			{
+				adminWebhooks.GET("", handlers.GetWebhookEvents)
+				adminWebhooks.GET("/:id", handlers.GetWebhookEvent)
+				adminWebhooks.POST("/:id/replay", handlers.ReplayWebhookEvent)
+			}
+
+			// Orders
+			adminOrders := admin.Group("/orders", middleware.RequirePermission(models.PermOrdersFulfil))
+			{
+				adminOrders.GET("", handlers.GetAllOrders)
+				adminOrders.GET("/:id", handlers.GetAdminOrder)
+				adminOrders.PUT("/:id/status", handlers.UpdateOrderStatus)
+			}
+
+			// Refunds
+			admin.POST("/orders/:id/refunds", middleware.RequirePermission(models.PermOrdersRefund), middleware.Idempotent(), handlers.CreateRefund)
+			adminRefunds := admin.Group("/refunds", middleware.RequirePermission(models.PermOrdersRefund))
+			{
+				adminRefunds.GET("", handlers.GetRefunds)
+				adminRefunds.POST("/:id/retry", handlers.RetryRefund)
+				adminRefunds.POST("/:id/cancel", handlers.CancelRefund)
+			}
+
+			// Returns; refunding one also takes the refund permission
+			adminReturns := admin.Group("/returns", middleware.RequirePermission(models.PermReturnsManage))
+			{
+				adminReturns.GET("", handlers.GetReturns)
+				adminReturns.GET("/:id", handlers.GetReturn)
+				adminReturns.POST("/:id/approve", handlers.ApproveReturn)
+				adminReturns.POST("/:id/reject", handlers.RejectReturn)
+				adminReturns.POST("/:id/receive", handlers.ReceiveReturn)
+				adminReturns.POST("/:id/refund", middleware.RequirePermission(models.PermOrdersRefund), handlers.RefundReturn)
+			}
 
 			// Products
-			admin.GET("/products", handlers.GetProducts)
-			admin.POST("/products", handlers.CreateProduct)
-			admin.PUT("/products/:id", handlers.UpdateProduct)
-			admin.DELETE("/products/:id", handlers.DeleteProduct)
+			adminProducts := admin.Group("/products", middleware.RequirePermission(models.PermProductsWrite))
+			{
+				adminProducts.GET("", handlers.GetProducts)
+				adminProducts.GET("/export", handlers.ExportProducts)
+				adminProducts.GET("/import", handlers.GetImportJobs)
+				adminProducts.GET("/import/:jobId", handlers.GetImportJob)
+				adminProducts.POST("/import", middleware.Idempotent(), handlers.ImportProducts)
+				adminProducts.POST("", middleware.Idempotent(), handlers.CreateProduct)
+				adminProducts.PUT("/:id", handlers.UpdateProduct)
+				adminProducts.DELETE("/:id", handlers.DeleteProduct)
+				adminProducts.GET("/trash", handlers.GetTrashedProducts)
+				adminProducts.POST("/:id/restore", handlers.RestoreProduct)
+				adminProducts.DELETE("/:id/purge", handlers.PurgeProduct)
+
+				// Variants
+				adminProducts.POST("/:id/options", middleware.Idempotent(), handlers.CreateProductOption)
+				adminProducts.DELETE("/:id/options/:optionId", handlers.DeleteProductOption)
+				adminProducts.POST("/:id/variants", middleware.Idempotent(), handlers.CreateVariant)
+				adminProducts.PUT("/:id/variants/:variantId", handlers.UpdateVariant)
+				adminProducts.DELETE("/:id/variants/:variantId", handlers.DeleteVariant)
+
+				// Images
+				adminProducts.POST("/:id/images", middleware.Idempotent(), handlers.AddProductImage)
+				adminProducts.PUT("/:id/images/order", handlers.ReorderProductImages)
+				adminProducts.DELETE("/:id/images/:imageId", handlers.DeleteProductImage)
+			}
+
+			// Categories
+			adminCategories := admin.Group("/categories", middleware.RequirePermission(models.PermProductsWrite))
+			{
+				adminCategories.GET("", handlers.GetCategories)
+				adminCategories.POST("", middleware.Idempotent(), handlers.CreateCategory)
+				adminCategories.PUT("/:id", handlers.UpdateCategory)
+				adminCategories.DELETE("/:id", handlers.DeleteCategory)
+			}
+
+			// Media library
+			adminMedia := admin.Group("/media", middleware.RequirePermission(models.PermMediaManage))
+			{
+				adminMedia.GET("", handlers.GetMedia)
+				adminMedia.POST("", middleware.Idempotent(), handlers.UploadMedia)
+				adminMedia.PUT("/:id", handlers.UpdateMedia)
+				adminMedia.DELETE("/:id", handlers.DeleteMedia)
+				adminMedia.POST("/:id/renditions", handlers.RegenerateRenditions)
+			}
+
+			// Reviews
+			adminReviews := admin.Group("/reviews", middleware.RequirePermission(models.PermReviewsModerate))
+			{
+				adminReviews.GET("", handlers.GetReviews)
+				adminReviews.PUT("/:id/status", handlers.UpdateReviewStatus)
+			}
 
 			// Vouchers
-			admin.GET("/vouchers", handlers.GetVouchers)
-			admin.POST("/vouchers", handlers.CreateVoucher)
-			admin.PUT("/vouchers/:id", handlers.UpdateVoucher)
-			admin.DELETE("/vouchers/:id", handlers.DeleteVoucher)
+			adminVouchers := admin.Group("/vouchers", middleware.RequirePermission(models.PermVouchersManage))
+			{
+				adminVouchers.GET("", handlers.GetVouchers)
+				adminVouchers.POST("", middleware.Idempotent(), handlers.CreateVoucher)
+				adminVouchers.PUT("/:id", handlers.UpdateVoucher)
+				adminVouchers.DELETE("/:id", handlers.DeleteVoucher)
+				adminVouchers.GET("/trash", handlers.GetTrashedVouchers)
+				adminVouchers.POST("/:id/restore", handlers.RestoreVoucher)
+				adminVouchers.DELETE("/:id/purge", handlers.PurgeVoucher)
+			}
 
 			// Blogs
-			admin.GET("/blogs", handlers.GetBlogs)
-			admin.POST("/blogs", handlers.CreateBlog)
-			admin.PUT("/blogs/:id", handlers.UpdateBlog)
-			admin.DELETE("/blogs/:id", handlers.DeleteBlog)
+			adminBlogs := admin.Group("/blogs", middleware.RequirePermission(models.PermBlogsPublish))
+			{
+				adminBlogs.GET("", handlers.GetBlogs)
+				adminBlogs.POST("", middleware.Idempotent(), handlers.CreateBlog)
+				adminBlogs.PUT("/:id", handlers.UpdateBlog)
+				adminBlogs.DELETE("/:id", handlers.DeleteBlog)
+				adminBlogs.GET("/trash", handlers.GetTrashedBlogs)
+				adminBlogs.POST("/:id/restore", handlers.RestoreBlog)
+				adminBlogs.DELETE("/:id/purge", handlers.PurgeBlog)
+			}
 		}
 	}
 }
@@ -0,0 +1,101 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"ecommerce-backend/utils"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+)
+
+// Highlight wraps every word-prefix match of terms in text with <mark> tags,
+// leaving the original accents intact. The rest of the text is HTML escaped.
+// When maxRunes is positive the result is cut to a snippet of about that many
+// runes around the first match.
+func Highlight(text string, terms []string, maxRunes int) string {
+	// Folded a rune at a time so that offsets line up with runes
+	runes := []rune(norm.NFC.String(text))
+	folded := make([]rune, len(runes))
+	for i, r := range runes {
+		folded[i] = utils.FoldRune(r)
+	}
+
+	// marked[i] is true for runes that are part of a match
+	marked := make([]bool, len(runes))
+	first := -1
+	for i := range folded {
+		if i > 0 && isWordRune(folded[i-1]) {
+			continue
+		}
+		for _, term := range terms {
+			t := []rune(term)
+			if hasPrefixAt(folded, t, i) {
+				for j := i; j < i+len(t); j++ {
+					marked[j] = true
+				}
+				if first < 0 {
+					first = i
+				}
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if maxRunes > 0 && len(runes) > maxRunes {
+		if first > maxRunes/3 {
+			start = first - maxRunes/3
+		}
+		end = start + maxRunes
+		if end > len(runes) {
+			end = len(runes)
+			start = end - maxRunes
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if marked[i] != inMark {
+			if marked[i] {
+				b.WriteString(markOpen)
+			} else {
+				b.WriteString(markClose)
+			}
+			inMark = marked[i]
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if inMark {
+		b.WriteString(markClose)
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func hasPrefixAt(s, prefix []rune, at int) bool {
+	if at+len(prefix) > len(s) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[at+i] != r {
+			return false
+		}
+	}
+	return true
+}
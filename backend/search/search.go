@@ -0,0 +1,121 @@
+// Package search implements accent-insensitive product search on top of
+// Postgres full-text search and trigram matching.
+//
+// Text is folded in Go (see utils.FoldAccents) into the search_name and
+// search_body columns, so "ghe cong thai hoc" finds "Ghế công thái học"
+// without the unaccent extension. A generated tsvector over those columns
+// gives ranked prefix matching, and a trigram index on search_name catches
+// typos. Category names and blog titles get the same folded column and
+// trigram index for search suggestions.
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"ecommerce-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxTerms = 8
+
+// Migrate installs pg_trgm and the search vector and indexes on products,
+// categories and blogs. It must run after those tables have been migrated.
+func Migrate(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', COALESCE(search_name, '')), 'A') ||
+			setweight(to_tsvector('simple', COALESCE(search_body, '')), 'B')
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search_name_trgm ON products USING GIN (search_name gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_categories_search_name_trgm ON categories USING GIN (search_name gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_blogs_search_title_trgm ON blogs USING GIN (search_title gin_trgm_ops)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	backfills := []struct{ table, source, target string }{
+		{"products", "name", "search_name"},
+		{"products", "description", "search_body"},
+		{"categories", "name", "search_name"},
+		{"blogs", "title", "search_title"},
+	}
+	for _, b := range backfills {
+		if err := backfill(db, b.table, b.source, b.target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfill fills a folded search column for rows saved before it existed.
+func backfill(db *gorm.DB, table, source, target string) error {
+	var rows []struct {
+		ID   uint
+		Text string
+	}
+	return db.Table(table).Select("id", source+" AS text").
+		Where("("+target+" IS NULL OR "+target+" = '') AND "+source+" <> ''").
+		FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				if err := tx.Table(table).Where("id = ?", row.ID).
+					UpdateColumn(target, utils.FoldAccents(row.Text)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// Query is a parsed search string.
+type Query struct {
+	Raw    string
+	Folded string
+	Terms  []string
+}
+
+// Parse folds q and splits it into at most maxTerms terms.
+func Parse(q string) Query {
+	folded := utils.FoldAccents(strings.TrimSpace(q))
+	terms := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxTerms {
+		terms = terms[:maxTerms]
+	}
+	return Query{Raw: q, Folded: strings.Join(terms, " "), Terms: terms}
+}
+
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0
+}
+
+// tsQuery matches every term as a word prefix, e.g. "ghe:* & cong:*".
+func (q Query) tsQuery() string {
+	parts := make([]string, len(q.Terms))
+	for i, term := range q.Terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Filter narrows tx to products whose text contains every term, or whose
+// name is a close trigram match for the query.
+func (q Query) Filter(tx *gorm.DB) *gorm.DB {
+	return tx.Where("(products.search_vector @@ to_tsquery('simple', ?) OR ? <% products.search_name)", q.tsQuery(), q.Folded)
+}
+
+// Rank returns the relevance expression used to order matches, highest
+// first. Name matches weigh more than description matches.
+func (q Query) Rank() clause.Expr {
+	return clause.Expr{
+		SQL:  "(ts_rank_cd(products.search_vector, to_tsquery('simple', ?)) + word_similarity(?, products.search_name))::float8",
+		Vars: []interface{}{q.tsQuery(), q.Folded},
+	}
+}
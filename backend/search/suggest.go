@@ -0,0 +1,25 @@
+package search
+
+import "gorm.io/gorm/clause"
+
+// MinSuggestLength is the shortest folded query worth suggesting for.
+const MinSuggestLength = 2
+
+// Contains matches rows whose folded column contains the query, or is a
+// close trigram match for it. Both forms can use the column's trigram index.
+func (q Query) Contains(column string) clause.Expr {
+	return clause.Expr{
+		SQL:  "(" + column + " LIKE ? OR ? <% " + column + ")",
+		Vars: []interface{}{"%" + q.Folded + "%", q.Folded},
+	}
+}
+
+// Closeness orders suggestions: values starting with the query first, then
+// by how closely they match it.
+func (q Query) Closeness(column string) clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:                "(" + column + " LIKE ?) DESC, word_similarity(?, " + column + ") DESC",
+		Vars:               []interface{}{q.Folded + "%", q.Folded},
+		WithoutParentheses: true,
+	}}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// S3Stub is an in-memory, S3-compatible object server for tests and offline
+// development, in the spirit of a local MinIO. It serves path-style requests
+// (/bucket/key), checks their SigV4 signatures and supports PUT, GET, HEAD
+// and DELETE of single objects. Run it with httptest.NewServer and point an
+// S3Storage with PathStyle at it.
+type S3Stub struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	mu      sync.Mutex
+	objects map[string]stubObject
+}
+
+type stubObject struct {
+	data        []byte
+	contentType string
+}
+
+func NewS3Stub(accessKey, secretKey, region string) *S3Stub {
+	return &S3Stub{AccessKey: accessKey, SecretKey: secretKey, Region: region, objects: map[string]stubObject{}}
+}
+
+// Object returns the stored bytes of bucket/key.
+func (s *S3Stub) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj.data, ok
+}
+
+func (s *S3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		stubError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if !s.verify(r, body) {
+		stubError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(name, "/") {
+		stubError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[name] = stubObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := s.objects[name]
+		if !ok {
+			stubError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		stubError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify recomputes the request's SigV4 signature and payload hash.
+func (s *S3Stub) verify(r *http.Request, body []byte) bool {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), signAlgorithm+" ")
+	fields := map[string]string{}
+	for _, part := range strings.Split(auth, ", ") {
+		if name, value, ok := strings.Cut(part, "="); ok {
+			fields[name] = value
+		}
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != s.AccessKey {
+		return false
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex(body) {
+		return false
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len(amzDateFormat) {
+		return false
+	}
+	scope, expected := signature(r, strings.Split(fields["SignedHeaders"], ";"), s.SecretKey, s.Region, "s3", amzDate)
+	return scope == credential[1] && hmac.Equal([]byte(expected), []byte(fields["Signature"]))
+}
+
+func stubError(w http.ResponseWriter, status int, code string) {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>`)
+	b.WriteString(code)
+	b.WriteString(`</Code></Error>`)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,57 @@
+// Package storage stores uploaded files such as product images.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage keeps objects under slash-separated keys, e.g.
+// "media/2024/05/3f2a9c.jpg".
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get returns ErrNotFound when no object is stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds when the object is already gone.
+	Delete(ctx context.Context, key string) error
+	// URL is the public address the object is served from.
+	URL(key string) string
+}
+
+// FromEnv builds a storage backend from the environment. STORAGE_DRIVER
+// selects "local" (the default) or "s3".
+func FromEnv() Storage {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "s3":
+		pathStyle, err := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		if err != nil {
+			pathStyle = os.Getenv("S3_ENDPOINT") != ""
+		}
+		return &S3Storage{
+			Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+			PathStyle: pathStyle,
+		}
+	default:
+		dir := getEnv("UPLOAD_DIR", "uploads")
+		log.Printf("Uploads will be stored in %s", dir)
+		return &LocalStorage{Dir: dir, BaseURL: getEnv("UPLOAD_BASE_URL", "/uploads")}
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
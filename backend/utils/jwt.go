@@ -8,33 +8,47 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
-func GenerateJWT(userID uint) (string, error) {
+// GenerateJWT issues a short-lived access token bound to a login session.
+func GenerateJWT(userID, sessionID uint) (string, error) {
 	if len(jwtSecret) == 0 {
 		jwtSecret = []byte("your-secret-key") // fallback
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 	})
 	return token.SignedString(jwtSecret)
 }
 
-func ValidateJWT(tokenString string) (uint, error) {
+// ValidateJWT returns the user and session IDs carried by an access token.
+func ValidateJWT(tokenString string) (uint, uint, error) {
 	if len(jwtSecret) == 0 {
 		jwtSecret = []byte("your-secret-key")
 	}
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return jwtSecret, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userID, ok := claims["user_id"].(float64); ok {
-			return uint(userID), nil
+		userID, ok := claims["user_id"].(float64)
+		if !ok {
+			return 0, 0, errors.New("invalid token")
 		}
+		sessionID, ok := claims["sid"].(float64)
+		if !ok {
+			return 0, 0, errors.New("invalid token")
+		}
+		return uint(userID), uint(sessionID), nil
 	}
-	return 0, errors.New("invalid token")
-}
\ No newline at end of file
+	return 0, 0, errors.New("invalid token")
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldTable maps every accented Vietnamese letter to its base letter.
+var foldTable = map[rune]rune{}
+
+func init() {
+	groups := map[rune]string{
+		'a': "àáảãạăằắẳẵặâầấẩẫậ",
+		'e': "èéẻẽẹêềếểễệ",
+		'i': "ìíỉĩị",
+		'o': "òóỏõọôồốổỗộơờớởỡợ",
+		'u': "ùúủũụưừứửữự",
+		'y': "ỳýỷỹỵ",
+		'd': "đ",
+	}
+	for base, accented := range groups {
+		for _, r := range accented {
+			foldTable[r] = base
+		}
+	}
+}
+
+// FoldAccents lowercases s and strips Vietnamese diacritics, so that
+// "Ghế Công Thái Học" becomes "ghe cong thai hoc". Text typed with combining
+// marks (NFD, as macOS keyboards produce) is composed first and any marks
+// left over are dropped, so it folds the same as precomposed text.
+func FoldAccents(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return FoldRune(r)
+	}, norm.NFC.String(s))
+}
+
+// FoldRune lowercases r and strips its Vietnamese diacritics. Callers that
+// need folded text to line up rune for rune with the original should compose
+// it with norm.NFC and fold it a rune at a time.
+func FoldRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if base, ok := foldTable[r]; ok {
+		return base
+	}
+	return r
+}
+
+// Slugify turns s into a lowercase ASCII slug such as "ghe-cong-thai-hoc".
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range FoldAccents(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
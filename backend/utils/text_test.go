@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestFoldAccents(t *testing.T) {
+	tests := map[string]string{
+		"Ghế Công Thái Học": "ghe cong thai hoc",
+		"ĐÈN NGỦ":           "den ngu",
+		"Bàn 1m2":           "ban 1m2",
+		// Decomposed input, as typed on macOS
+		norm.NFD.String("Ghế Công Thái Học"): "ghe cong thai hoc",
+		norm.NFD.String("Đèn ngủ"):           "den ngu",
+		// A combining mark with no precomposed letter is dropped
+		"q́uạt": "quat",
+	}
+	for in, want := range tests {
+		if got := FoldAccents(in); got != want {
+			t.Errorf("FoldAccents(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Ghế Công Thái Học":                  "ghe-cong-thai-hoc",
+		norm.NFD.String("Ghế Công Thái Học"): "ghe-cong-thai-hoc",
+		"  Bàn / Ghế -- 2024 ":               "ban-ghe-2024",
+	}
+	for in, want := range tests {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -1,4 +1,6 @@
 const API_BASE_URL = 'http://localhost:8080';
+// The largest page the API serves
+const LIST_PAGE_SIZE = 100;
 
 interface LoginData {
   email: string;
@@ -11,13 +13,22 @@ interface RegisterData {
   name: string;
 }
 
+interface Category {
+  id: number;
+  parent_id: number | null;
+  name: string;
+  slug: string;
+  children?: Category[];
+}
+
 interface Product {
   id: number;
   name: string;
   description: string;
   price: number;
   image_url: string;
-  category: string;
+  category_id: number | null;
+  category?: Category;
   stock: number;
 }
 
@@ -43,8 +54,45 @@ interface Order {
   created_at: string;
 }
 
+export interface ShippingAddress {
+  recipient_name: string;
+  phone: string;
+  street: string;
+  ward: string;
+  district: string;
+  province: string;
+}
+
+export interface Address extends ShippingAddress {
+  id: number;
+  user_id: number;
+  is_default: boolean;
+}
+
+// An order ships to a saved address or to one given inline. Without either the
+// customer's default address is used.
+export interface CheckoutData {
+  address_id?: number;
+  address?: ShippingAddress;
+  voucher_code?: string;
+  payment_method?: string;
+}
+
+// List endpoints answer with one page of items. next_cursor, when set, can be
+// passed back as the cursor parameter to get the next page.
+export interface Page<T> {
+  items: T[];
+  total: number;
+  page?: number;
+  page_size: number;
+  next_cursor?: string;
+}
+
 class ApiService {
   private token: string | null = null;
+  // Shared by requests that hit an expired access token at the same time, so
+  // the refresh token is only rotated once
+  private refreshing: Promise<boolean> | null = null;
 
   setToken(token: string) {
     this.token = token;
@@ -55,7 +103,45 @@ class ApiService {
     return this.token || localStorage.getItem('token');
   }
 
-  private async request(endpoint: string, options: RequestInit = {}): Promise<any> {
+  private setTokens(result: { token: string; refresh_token: string }) {
+    this.setToken(result.token);
+    localStorage.setItem('refresh_token', result.refresh_token);
+  }
+
+  private clearTokens() {
+    this.token = null;
+    localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
+  }
+
+  // refreshTokens trades the refresh token for a new pair. Access tokens
+  // expire after a few minutes, so this runs whenever the API answers 401.
+  private refreshTokens(): Promise<boolean> {
+    const refreshToken = localStorage.getItem('refresh_token');
+    if (!refreshToken) return Promise.resolve(false);
+    if (!this.refreshing) {
+      this.refreshing = fetch(`${API_BASE_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      })
+        .then(async response => {
+          if (!response.ok) {
+            this.clearTokens();
+            return false;
+          }
+          this.setTokens(await response.json());
+          return true;
+        })
+        .catch(() => false)
+        .finally(() => {
+          this.refreshing = null;
+        });
+    }
+    return this.refreshing;
+  }
+
+  private async request(endpoint: string, options: RequestInit = {}, retried = false): Promise<any> {
     const url = `${API_BASE_URL}${endpoint}`;
     const headers: HeadersInit = {
       'Content-Type': 'application/json',
@@ -73,6 +159,10 @@ class ApiService {
       },
     });
 
+    if (response.status === 401 && !retried && await this.refreshTokens()) {
+      return this.request(endpoint, options, true);
+    }
+
     if (!response.ok) {
       throw new Error(`API Error: ${response.statusText}`);
     }
@@ -91,7 +181,7 @@ class ApiService {
     return response.json();
   }
 
-  async login(data: LoginData): Promise<{ token: string; user: any }> {
+  async login(data: LoginData): Promise<{ token: string; refresh_token: string; user: any }> {
     const response = await fetch(`${API_BASE_URL}/auth/login`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
@@ -99,19 +189,40 @@ class ApiService {
     });
     if (!response.ok) throw new Error('Login failed');
     const result = await response.json();
-    this.setToken(result.token);
+    this.setTokens(result);
     return result;
   }
 
+  // logout ends the session on the server too, so its refresh token can no
+  // longer be used.
+  async logout(): Promise<void> {
+    const refreshToken = localStorage.getItem('refresh_token');
+    this.clearTokens();
+    if (!refreshToken) return;
+    try {
+      await fetch(`${API_BASE_URL}/auth/logout`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+    } catch (error) {
+      console.error('Failed to log out:', error);
+    }
+  }
+
   // Products
-  async getProducts(): Promise<Product[]> {
-    return this.request('/api/products');
+  async getProducts(): Promise<Page<Product>> {
+    return this.request(`/api/products?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async getProduct(id: number): Promise<Product> {
     return this.request(`/api/products/${id}`);
   }
 
+  async getCategories(): Promise<Category[]> {
+    return this.request('/api/categories');
+  }
+
   async createProduct(product: Omit<Product, 'id'>): Promise<Product> {
     return this.request('/api/products', {
       method: 'POST',
@@ -157,15 +268,28 @@ class ApiService {
     });
   }
 
+  // Addresses
+  async getAddresses(): Promise<Address[]> {
+    return this.request('/api/addresses');
+  }
+
+  async createAddress(address: ShippingAddress & { is_default?: boolean }): Promise<Address> {
+    return this.request('/api/addresses', {
+      method: 'POST',
+      body: JSON.stringify(address),
+    });
+  }
+
   // Orders
-  async createOrder(): Promise<Order> {
+  async createOrder(checkout: CheckoutData = {}): Promise<Order> {
     return this.request('/api/orders', {
       method: 'POST',
+      body: JSON.stringify(checkout),
     });
   }
 
-  async getOrders(): Promise<Order[]> {
-    return this.request('/api/orders');
+  async getOrders(): Promise<Page<Order>> {
+    return this.request(`/api/orders?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async getOrder(id: number): Promise<Order> {
@@ -177,9 +301,10 @@ class ApiService {
     return this.request('/api/admin/dashboard');
   }
 
-  async getUsers(role?: string): Promise<any[]> {
-    const url = role ? `/api/admin/users?role=${role}` : '/api/admin/users';
-    return this.request(url);
+  async getUsers(role?: string): Promise<Page<any>> {
+    const params = new URLSearchParams({ page_size: String(LIST_PAGE_SIZE) });
+    if (role) params.set('role', role);
+    return this.request(`/api/admin/users?${params}`);
   }
 
   async createUser(user: any): Promise<any> {
@@ -202,8 +327,8 @@ class ApiService {
     });
   }
 
-  async getAdminProducts(): Promise<any[]> {
-    return this.request('/api/admin/products');
+  async getAdminProducts(): Promise<Page<any>> {
+    return this.request(`/api/admin/products?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async createAdminProduct(product: any): Promise<any> {
@@ -226,8 +351,8 @@ class ApiService {
     });
   }
 
-  async getVouchers(): Promise<any[]> {
-    return this.request('/api/admin/vouchers');
+  async getVouchers(): Promise<Page<any>> {
+    return this.request(`/api/admin/vouchers?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async createVoucher(voucher: any): Promise<any> {
@@ -250,16 +375,16 @@ class ApiService {
     });
   }
 
-  async getPublishedBlogs(): Promise<any[]> {
-    return this.request('/api/blogs');
+  async getPublishedBlogs(): Promise<Page<any>> {
+    return this.request(`/api/blogs?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async getPublishedBlog(id: number): Promise<any> {
     return this.request(`/api/blogs/${id}`);
   }
 
-  async getBlogs(): Promise<any[]> {
-    return this.request('/api/admin/blogs');
+  async getBlogs(): Promise<Page<any>> {
+    return this.request(`/api/admin/blogs?page_size=${LIST_PAGE_SIZE}`);
   }
 
   async createBlog(blog: any): Promise<any> {